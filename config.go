@@ -0,0 +1,587 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/shurcooL/githubv4"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// metricNamePart matches valid Prometheus metric name prefixes
+var metricNamePart = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// config holds all non-secret settings. It is read from the YAML file CONFIG_FILE points to, if
+// any, and environment variables override the file's values.
+type config struct {
+	Repos        []string `yaml:"repos"`
+	Search       string   `yaml:"search"`
+	Org          string   `yaml:"org"`
+	ExcludeRepos []string `yaml:"excludeRepos"`
+	GitHubAPIURL string   `yaml:"githubAPIURL"`
+	GitHubCACert string   `yaml:"githubCACert"`
+	ListenAddr   string   `yaml:"listenAddr"`
+	TLSCertFile  string   `yaml:"tlsCertFile"`
+	TLSKeyFile   string   `yaml:"tlsKeyFile"`
+
+	PollInterval  time.Duration `yaml:"pollInterval"`
+	PollTimeout   time.Duration `yaml:"pollTimeout"`
+	MaxPages      int           `yaml:"maxPages"`
+	Concurrency   int           `yaml:"concurrency"`
+	SkipUnchanged bool          `yaml:"skipUnchanged"`
+	// SkipUnchangedMaxAge is the age after which reused pull requests are downloaded anyway
+	SkipUnchangedMaxAge time.Duration `yaml:"skipUnchangedMaxAge"`
+	PRStates            []string      `yaml:"prStates"`
+	HistoryWindow       time.Duration `yaml:"historyWindow"`
+	Retry               struct {
+		MaxAttempts int           `yaml:"maxAttempts"`
+		BaseDelay   time.Duration `yaml:"baseDelay"`
+	} `yaml:"retry"`
+
+	OverdueAfter        time.Duration            `yaml:"overdueAfter"`
+	OverdueAfterByRepo  map[string]time.Duration `yaml:"overdueAfterByRepo"`
+	BusinessHours       string                   `yaml:"businessHours"`
+	BusinessTimezone    string                   `yaml:"businessTimezone"`
+	AwaitingAuthorAfter time.Duration            `yaml:"awaitingAuthorAfter"`
+	ApprovedStaleAfter  time.Duration            `yaml:"approvedStaleAfter"`
+	StaleDraftAfter     time.Duration            `yaml:"staleDraftAfter"`
+	ReviewWindow        time.Duration            `yaml:"reviewWindow"`
+	FilterLabel         string                   `yaml:"filterLabel"`
+	BaseBranch          string                   `yaml:"baseBranch"`
+	Milestone           string                   `yaml:"milestone"`
+	IgnoreAuthors       []string                 `yaml:"ignoreAuthors"`
+	IgnoreForks         bool                     `yaml:"ignoreForks"`
+	OpenExcludesDrafts  bool                     `yaml:"openExcludesDrafts"`
+	RequiredApprovers   []string                 `yaml:"requiredApprovers"`
+	MinPRAge            time.Duration            `yaml:"minPRAge"`
+	CommentedStates     []string                 `yaml:"commentedStates"`
+
+	SnapshotFile     string `yaml:"snapshotFile"`
+	SnapshotMaxBytes int    `yaml:"snapshotMaxBytes"`
+
+	SlackWebhookURL       string `yaml:"slackWebhookURL"`
+	NotificationTemplate  string `yaml:"notificationTemplate"`
+	CriticalOverdueFactor int    `yaml:"criticalOverdueFactor"`
+
+	OverdueSmoothing float64 `yaml:"overdueSmoothing"`
+
+	DigestRepo     string `yaml:"digestRepo"`
+	DigestTitle    string `yaml:"digestTitle"`
+	DigestTimezone string `yaml:"digestTimezone"`
+
+	MetricsNamespace string   `yaml:"metricsNamespace"`
+	MetricsSubsystem string   `yaml:"metricsSubsystem"`
+	LabelAllowlist   []string `yaml:"labelAllowlist"`
+
+	LogLevel  string `yaml:"logLevel"`
+	LogFormat string `yaml:"logFormat"`
+
+	// repos are the parsed Repos, populated by validate
+	repos []prbot.Repository
+	// excludeRepos are the parsed ExcludeRepos, populated by validate
+	excludeRepos []prbot.Repository
+	// businessHours are the parsed BusinessHours, populated by validate
+	businessHours *prbot.BusinessHours
+	// prStates are the parsed PRStates, populated by validate
+	prStates []githubv4.PullRequestState
+	// commentedStates are the parsed CommentedStates, populated by validate
+	commentedStates []githubv4.PullRequestReviewState
+	// digestRepo is the parsed DigestRepo, populated by validate
+	digestRepo *prbot.Repository
+	// digestLocation is the loaded DigestTimezone, populated by validate
+	digestLocation *time.Location
+	// notificationTemplate is the parsed NotificationTemplate, populated by validate
+	notificationTemplate *template.Template
+}
+
+func defaultConfig() *config {
+	cfg := &config{
+		Repos:                 []string{"gitpod-io/gitpod"},
+		ListenAddr:            ":9500",
+		PollInterval:          10 * time.Minute,
+		PollTimeout:           2 * time.Minute,
+		MaxPages:              50,
+		Concurrency:           4,
+		PRStates:              []string{"OPEN"},
+		HistoryWindow:         30 * 24 * time.Hour,
+		SkipUnchangedMaxAge:   time.Hour,
+		OverdueAfter:          24 * time.Hour,
+		AwaitingAuthorAfter:   48 * time.Hour,
+		ApprovedStaleAfter:    72 * time.Hour,
+		BusinessTimezone:      "UTC",
+		StaleDraftAfter:       7 * 24 * time.Hour,
+		ReviewWindow:          24 * time.Hour,
+		IgnoreAuthors:         []string{"dependabot", "renovate"},
+		CommentedStates:       []string{"COMMENTED"},
+		SnapshotMaxBytes:      100 << 20,
+		NotificationTemplate:  defaultNotificationTemplate,
+		CriticalOverdueFactor: 3,
+		OverdueSmoothing:      0.3,
+		DigestTitle:           "Pull request digest",
+		DigestTimezone:        "UTC",
+		MetricsNamespace:      "github",
+		MetricsSubsystem:      "gitpod_io",
+		LogLevel:              "info",
+		LogFormat:             "text",
+	}
+	cfg.Retry.MaxAttempts = 3
+	cfg.Retry.BaseDelay = 2 * time.Second
+	return cfg
+}
+
+// loadConfig reads the configuration from defaults, the config file and the environment, in
+// increasing order of precedence
+func loadConfig() (*config, error) {
+	cfg := defaultConfig()
+	if fn := os.Getenv("CONFIG_FILE"); fn != "" {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config file: %v", err)
+		}
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		err = dec.Decode(cfg)
+		f.Close()
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("cannot parse config file %s: %v", fn, err)
+		}
+	}
+
+	err := cfg.applyEnv()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *config) applyEnv() error {
+	if v := os.Getenv("REPOS"); v != "" {
+		cfg.Repos = splitList(v)
+	} else if owner, name := os.Getenv("REPO_OWNER"), os.Getenv("REPO_NAME"); owner != "" || name != "" {
+		cfg.Repos = []string{getEnv("REPO_OWNER", "gitpod-io") + "/" + getEnv("REPO_NAME", "gitpod")}
+	}
+	envString("SEARCH_QUERY", &cfg.Search)
+	envString("ORG", &cfg.Org)
+	if v := os.Getenv("EXCLUDE_REPOS"); v != "" {
+		cfg.ExcludeRepos = splitList(v)
+	}
+	envString("GITHUB_API_URL", &cfg.GitHubAPIURL)
+	envString("GITHUB_CA_CERT", &cfg.GitHubCACert)
+	envString("LISTEN_ADDR", &cfg.ListenAddr)
+	envString("TLS_CERT_FILE", &cfg.TLSCertFile)
+	envString("TLS_KEY_FILE", &cfg.TLSKeyFile)
+
+	envDuration("POLL_INTERVAL", &cfg.PollInterval)
+	envDuration("POLL_TIMEOUT", &cfg.PollTimeout)
+	err := envInt("MAX_PAGES", &cfg.MaxPages)
+	if err != nil {
+		return err
+	}
+	err = envInt("CONCURRENCY", &cfg.Concurrency)
+	if err != nil {
+		return err
+	}
+	err = envInt("RETRY_MAX_ATTEMPTS", &cfg.Retry.MaxAttempts)
+	if err != nil {
+		return err
+	}
+	envDuration("RETRY_BASE_DELAY", &cfg.Retry.BaseDelay)
+	if v := os.Getenv("PR_STATES"); v != "" {
+		cfg.PRStates = splitList(v)
+	}
+	envDuration("HISTORY_WINDOW", &cfg.HistoryWindow)
+
+	envDuration("OVERDUE_AFTER", &cfg.OverdueAfter)
+	err = envDurationMap("OVERDUE_AFTER_BY_REPO", &cfg.OverdueAfterByRepo)
+	if err != nil {
+		return err
+	}
+	envString("BUSINESS_HOURS", &cfg.BusinessHours)
+	envString("BUSINESS_TIMEZONE", &cfg.BusinessTimezone)
+	envDuration("AWAITING_AUTHOR_AFTER", &cfg.AwaitingAuthorAfter)
+	envDuration("APPROVED_STALE_AFTER", &cfg.ApprovedStaleAfter)
+	envDuration("STALE_DRAFT_AFTER", &cfg.StaleDraftAfter)
+	envDuration("REVIEW_WINDOW", &cfg.ReviewWindow)
+	envDuration("MIN_PR_AGE", &cfg.MinPRAge)
+	if v := os.Getenv("COMMENTED_STATES"); v != "" {
+		cfg.CommentedStates = splitList(v)
+	}
+	envString("FILTER_LABEL", &cfg.FilterLabel)
+	envString("BASE_BRANCH", &cfg.BaseBranch)
+	envString("MILESTONE", &cfg.Milestone)
+	// an empty IGNORE_AUTHORS ignores no one, hence we only check if it's set at all
+	if v, ok := os.LookupEnv("IGNORE_AUTHORS"); ok {
+		cfg.IgnoreAuthors = splitList(v)
+	}
+
+	if v := os.Getenv("REQUIRED_APPROVERS"); v != "" {
+		cfg.RequiredApprovers = splitList(v)
+	}
+
+	err = envBool("SKIP_UNCHANGED", &cfg.SkipUnchanged)
+	if err != nil {
+		return err
+	}
+	envDuration("SKIP_UNCHANGED_MAX_AGE", &cfg.SkipUnchangedMaxAge)
+	err = envBool("IGNORE_FORKS", &cfg.IgnoreForks)
+	if err != nil {
+		return err
+	}
+	err = envBool("OPEN_EXCLUDES_DRAFTS", &cfg.OpenExcludesDrafts)
+	if err != nil {
+		return err
+	}
+
+	envString("SNAPSHOT_FILE", &cfg.SnapshotFile)
+	err = envInt("SNAPSHOT_MAX_BYTES", &cfg.SnapshotMaxBytes)
+	if err != nil {
+		return err
+	}
+
+	envString("SLACK_WEBHOOK_URL", &cfg.SlackWebhookURL)
+	envString("NOTIFICATION_TEMPLATE", &cfg.NotificationTemplate)
+	if fn := os.Getenv("NOTIFICATION_TEMPLATE_FILE"); fn != "" {
+		b, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return fmt.Errorf("cannot read NOTIFICATION_TEMPLATE_FILE: %v", err)
+		}
+		cfg.NotificationTemplate = strings.TrimRight(string(b), "\n")
+	}
+	err = envInt("CRITICAL_OVERDUE_FACTOR", &cfg.CriticalOverdueFactor)
+	if err != nil {
+		return err
+	}
+
+	err = envFloat("OVERDUE_SMOOTHING", &cfg.OverdueSmoothing)
+	if err != nil {
+		return err
+	}
+
+	envString("DIGEST_REPO", &cfg.DigestRepo)
+	envString("DIGEST_TITLE", &cfg.DigestTitle)
+	envString("DIGEST_TIMEZONE", &cfg.DigestTimezone)
+
+	// empty values are valid and drop that part of the metric names
+	if v, ok := os.LookupEnv("METRICS_NAMESPACE"); ok {
+		cfg.MetricsNamespace = v
+	}
+	if v, ok := os.LookupEnv("METRICS_SUBSYSTEM"); ok {
+		cfg.MetricsSubsystem = v
+	}
+
+	if v := os.Getenv("LABEL_ALLOWLIST"); v != "" {
+		cfg.LabelAllowlist = splitList(v)
+	}
+
+	envString("LOG_LEVEL", &cfg.LogLevel)
+	envString("LOG_FORMAT", &cfg.LogFormat)
+	return nil
+}
+
+func (cfg *config) validate() error {
+	cfg.repos = nil
+	for _, r := range cfg.Repos {
+		repo, err := prbot.ParseRepo(r)
+		if err != nil {
+			return err
+		}
+		cfg.repos = append(cfg.repos, repo)
+	}
+	if len(cfg.repos) == 0 && cfg.Search == "" && cfg.Org == "" {
+		return fmt.Errorf("no repositories configured")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("tlsCertFile and tlsKeyFile must be set together")
+	}
+	cfg.excludeRepos = nil
+	for _, r := range cfg.ExcludeRepos {
+		repo, err := prbot.ParseRepo(r)
+		if err != nil {
+			return fmt.Errorf("invalid excludeRepos: %v", err)
+		}
+		cfg.excludeRepos = append(cfg.excludeRepos, repo)
+	}
+
+	for _, d := range []struct {
+		Name  string
+		Value time.Duration
+	}{
+		{"pollInterval", cfg.PollInterval},
+		{"pollTimeout", cfg.PollTimeout},
+		{"retry.baseDelay", cfg.Retry.BaseDelay},
+		{"overdueAfter", cfg.OverdueAfter},
+		{"awaitingAuthorAfter", cfg.AwaitingAuthorAfter},
+		{"approvedStaleAfter", cfg.ApprovedStaleAfter},
+		{"staleDraftAfter", cfg.StaleDraftAfter},
+		{"reviewWindow", cfg.ReviewWindow},
+		{"historyWindow", cfg.HistoryWindow},
+		{"skipUnchangedMaxAge", cfg.SkipUnchangedMaxAge},
+	} {
+		if d.Value <= 0 {
+			return fmt.Errorf("%s must be a positive duration, got %s", d.Name, d.Value)
+		}
+	}
+	if cfg.MinPRAge < 0 {
+		return fmt.Errorf("minPRAge must not be negative, got %s", cfg.MinPRAge)
+	}
+	for repo, d := range cfg.OverdueAfterByRepo {
+		_, err := prbot.ParseRepo(repo)
+		if err != nil {
+			return fmt.Errorf("invalid overdueAfterByRepo: %v", err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("overdueAfterByRepo of %s must be a positive duration, got %s", repo, d)
+		}
+	}
+	cfg.businessHours = nil
+	if cfg.BusinessHours != "" {
+		bh, err := prbot.ParseBusinessHours(cfg.BusinessHours, cfg.BusinessTimezone)
+		if err != nil {
+			return err
+		}
+		cfg.businessHours = bh
+	}
+	cfg.prStates = nil
+	for _, st := range cfg.PRStates {
+		state := githubv4.PullRequestState(strings.ToUpper(st))
+		switch state {
+		case githubv4.PullRequestStateOpen, githubv4.PullRequestStateClosed, githubv4.PullRequestStateMerged:
+		default:
+			return fmt.Errorf("invalid prStates entry %q: expected OPEN, CLOSED or MERGED", st)
+		}
+		cfg.prStates = append(cfg.prStates, state)
+	}
+	if len(cfg.prStates) == 0 {
+		return fmt.Errorf("prStates must not be empty")
+	}
+	cfg.commentedStates = nil
+	for _, st := range cfg.CommentedStates {
+		state := githubv4.PullRequestReviewState(strings.ToUpper(st))
+		switch state {
+		case githubv4.PullRequestReviewStateCommented, githubv4.PullRequestReviewStateDismissed, githubv4.PullRequestReviewStatePending:
+		default:
+			return fmt.Errorf("invalid commentedStates entry %q: expected COMMENTED, DISMISSED or PENDING", st)
+		}
+		cfg.commentedStates = append(cfg.commentedStates, state)
+	}
+	if cfg.MaxPages <= 0 {
+		return fmt.Errorf("maxPages must be positive, got %d", cfg.MaxPages)
+	}
+	tmpl, err := parseNotificationTemplate(cfg.NotificationTemplate)
+	if err != nil {
+		return err
+	}
+	cfg.notificationTemplate = tmpl
+	if cfg.CriticalOverdueFactor <= 0 {
+		return fmt.Errorf("criticalOverdueFactor must be positive, got %d", cfg.CriticalOverdueFactor)
+	}
+	if cfg.SnapshotMaxBytes <= 0 {
+		return fmt.Errorf("snapshotMaxBytes must be positive, got %d", cfg.SnapshotMaxBytes)
+	}
+	if cfg.OverdueSmoothing <= 0 || cfg.OverdueSmoothing > 1 {
+		return fmt.Errorf("overdueSmoothing must be greater than 0 and at most 1, got %v", cfg.OverdueSmoothing)
+	}
+	cfg.digestRepo = nil
+	if cfg.DigestRepo != "" {
+		repo, err := prbot.ParseRepo(cfg.DigestRepo)
+		if err != nil {
+			return fmt.Errorf("invalid digestRepo: %v", err)
+		}
+		cfg.digestRepo = &repo
+		if cfg.DigestTitle == "" {
+			return fmt.Errorf("digestTitle must not be empty")
+		}
+		cfg.digestLocation, err = time.LoadLocation(cfg.DigestTimezone)
+		if err != nil {
+			return fmt.Errorf("invalid digestTimezone: %v", err)
+		}
+	}
+	if cfg.Concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive, got %d", cfg.Concurrency)
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		return fmt.Errorf("retry.maxAttempts must be positive, got %d", cfg.Retry.MaxAttempts)
+	}
+
+	for i, approver := range cfg.RequiredApprovers {
+		// accept the CODEOWNERS notation
+		approver = strings.TrimPrefix(approver, "@")
+		if segs := strings.Split(approver, "/"); approver == "" || len(segs) > 2 || (len(segs) == 2 && (segs[0] == "" || segs[1] == "")) {
+			return fmt.Errorf("invalid requiredApprovers entry %q: expected a login or org/team", cfg.RequiredApprovers[i])
+		}
+		cfg.RequiredApprovers[i] = approver
+	}
+
+	for _, pattern := range cfg.IgnoreAuthors {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ignoreAuthors pattern %q: %v", pattern, err)
+		}
+	}
+
+	if _, err := log.ParseLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("invalid logLevel: %v", err)
+	}
+	for _, n := range []struct {
+		Name  string
+		Value string
+	}{
+		{"metricsNamespace", cfg.MetricsNamespace},
+		{"metricsSubsystem", cfg.MetricsSubsystem},
+	} {
+		if n.Value != "" && !metricNamePart.MatchString(n.Value) {
+			return fmt.Errorf("invalid %s %q: expected letters, digits and underscores", n.Name, n.Value)
+		}
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return fmt.Errorf("invalid logFormat %q: expected text or json", cfg.LogFormat)
+	}
+	return nil
+}
+
+// reportOptions returns the options prbot.ReportWIP is called with
+func (cfg *config) reportOptions() prbot.Options {
+	return prbot.Options{
+		OverdueAfter:        cfg.OverdueAfter,
+		OverdueAfterByRepo:  cfg.OverdueAfterByRepo,
+		BusinessHours:       cfg.businessHours,
+		AwaitingAuthorAfter: cfg.AwaitingAuthorAfter,
+		ApprovedStaleAfter:  cfg.ApprovedStaleAfter,
+		FilterLabel:         cfg.FilterLabel,
+		BaseBranch:          cfg.BaseBranch,
+		Milestone:           cfg.Milestone,
+		IgnoreAuthors:       cfg.IgnoreAuthors,
+		OpenExcludesDrafts:  cfg.OpenExcludesDrafts,
+		IgnoreForks:         cfg.IgnoreForks,
+		MinAge:              cfg.MinPRAge,
+		CommentedStates:     cfg.commentedStates,
+	}
+}
+
+func (cfg *config) metricsOptions() metricsOptions {
+	return metricsOptions{
+		StaleDraftAfter: cfg.StaleDraftAfter,
+		ReviewWindow:    cfg.ReviewWindow,
+	}
+}
+
+// getEnv returns the value of the environment variable key, or def if it is unset or empty.
+func getEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envString overrides dst with the environment variable key if it is set and not empty
+func envString(key string, dst *string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+// envDuration overrides dst with the environment variable key parsed as duration. If the
+// variable cannot be parsed, dst is left unchanged.
+func envDuration(key string, dst *time.Duration) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.WithError(err).WithField(key, v).Warnf("cannot parse %s, using %s instead", key, *dst)
+		return
+	}
+	*dst = d
+}
+
+// envDurationMap overrides dst with the environment variable key parsed as comma-separated
+// key=duration pairs
+func envDurationMap(key string, dst *map[string]time.Duration) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	res := make(map[string]time.Duration)
+	for _, pair := range splitList(v) {
+		segs := strings.SplitN(pair, "=", 2)
+		if len(segs) != 2 {
+			return fmt.Errorf("invalid %s entry %q: expected key=duration", key, pair)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(segs[1]))
+		if err != nil {
+			return fmt.Errorf("invalid %s entry %q: %v", key, pair, err)
+		}
+		res[strings.TrimSpace(segs[0])] = d
+	}
+	*dst = res
+	return nil
+}
+
+// envInt overrides dst with the environment variable key parsed as integer
+func envInt(key string, dst *int) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %v", key, err)
+	}
+	*dst = i
+	return nil
+}
+
+// envFloat overrides dst with the environment variable key parsed as floating point number
+func envFloat(key string, dst *float64) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %v", key, err)
+	}
+	*dst = f
+	return nil
+}
+
+// envBool overrides dst with the environment variable key parsed as boolean
+func envBool(key string, dst *bool) error {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("cannot parse %s: %v", key, err)
+	}
+	*dst = b
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty elements
+func splitList(s string) []string {
+	var res []string
+	for _, seg := range strings.Split(s, ",") {
+		seg = strings.TrimSpace(seg)
+		if seg == "" {
+			continue
+		}
+		res = append(res, seg)
+	}
+	return res
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	log "github.com/sirupsen/logrus"
+)
+
+// pullRequestStore holds the pull requests of each repository as downloaded by the most recent poll,
+// before any filtering
+type pullRequestStore struct {
+	mu  sync.RWMutex
+	prs map[string][]prbot.PullRequest
+}
+
+func newPullRequestStore() *pullRequestStore {
+	return &pullRequestStore{
+		prs: make(map[string][]prbot.PullRequest),
+	}
+}
+
+// Set replaces the pull requests of a repository
+func (s *pullRequestStore) Set(repo prbot.Repository, prs []prbot.PullRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prs[repo.String()] = prs
+}
+
+// Delete deletes the pull requests of a repository
+func (s *pullRequestStore) Delete(repo prbot.Repository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.prs, repo.String())
+}
+
+type rawReviewJSON struct {
+	State       string    `json:"state"`
+	SubmittedAt time.Time `json:"submittedAt"`
+}
+
+type rawPullRequestJSON struct {
+	Number    int             `json:"number"`
+	URL       string          `json:"url"`
+	Title     string          `json:"title"`
+	Author    string          `json:"author"`
+	IsDraft   bool            `json:"isDraft"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Reviews   []rawReviewJSON `json:"reviews"`
+}
+
+// ServeHTTP serves the most recently downloaded pull requests as JSON, keyed by repository.
+// This is meant for debugging the mapping of the GraphQL response only, the format is not stable.
+func (s *pullRequestStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	res := make(map[string][]rawPullRequestJSON, len(s.prs))
+	for repo, prs := range s.prs {
+		out := make([]rawPullRequestJSON, 0, len(prs))
+		for _, pr := range prs {
+			reviews := make([]rawReviewJSON, 0, len(pr.Reviews.Nodes))
+			for _, review := range pr.Reviews.Nodes {
+				reviews = append(reviews, rawReviewJSON{
+					State:       string(review.State),
+					SubmittedAt: review.SubmittedAt.Time,
+				})
+			}
+			out = append(out, rawPullRequestJSON{
+				Number:    int(pr.Number),
+				URL:       pr.Link(),
+				Title:     string(pr.Title),
+				Author:    pr.Author.Login,
+				IsDraft:   bool(pr.IsDraft),
+				CreatedAt: pr.CreatedAt.Time,
+				Reviews:   reviews,
+			})
+		}
+		res[repo] = out
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.WithError(err).Warn("cannot serve pull requests")
+	}
+}
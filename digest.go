@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/shurcooL/githubv4"
+)
+
+// digestNotifier keeps an issue summarizing the reports of all repositories up to date. The issue
+// is updated once per day, which requires a token that may write issues of Repo.
+type digestNotifier struct {
+	Client *githubv4.Client
+	Repo   prbot.Repository
+	// Title identifies the issue. The most recently created open issue with this title is updated,
+	// if there is none a new one is created.
+	Title string
+	// Location determines when a new day starts
+	Location *time.Location
+
+	// lastDay is the day the digest was last posted, as YYYY-MM-DD in Location
+	lastDay string
+	issueID githubv4.ID
+}
+
+// Post updates the digest issue with reports, keyed by repository, unless that happened on the
+// same day already. Failed updates are retried at the next call.
+func (d *digestNotifier) Post(ctx context.Context, now time.Time, reports map[string]prbot.Report) error {
+	day := now.In(d.Location).Format("2006-01-02")
+	if day == d.lastDay {
+		return nil
+	}
+
+	body := renderDigest(day, now, reports)
+	if d.issueID == nil {
+		repoID, issueID, err := d.findIssue(ctx)
+		if err != nil {
+			return err
+		}
+		if issueID == nil {
+			issueID, err = d.createIssue(ctx, repoID, body)
+			if err != nil {
+				return err
+			}
+			d.issueID = issueID
+			d.lastDay = day
+			return nil
+		}
+		d.issueID = issueID
+	}
+
+	var m struct {
+		UpdateIssue struct {
+			Issue struct {
+				Number int
+			}
+		} `graphql:"updateIssue(input: $input)"`
+	}
+	err := d.Client.Mutate(ctx, &m, githubv4.UpdateIssueInput{
+		ID:   d.issueID,
+		Body: githubv4.NewString(githubv4.String(body)),
+	}, nil)
+	if err != nil {
+		// the issue may have been deleted, look it up again next time
+		d.issueID = nil
+		return fmt.Errorf("cannot update digest issue: %v", err)
+	}
+	d.lastDay = day
+	return nil
+}
+
+// findIssue returns the ID of Repo, and that of the most recently created open issue titled Title
+// or nil if there is none. Open issues are paged through until one matches.
+func (d *digestNotifier) findIssue(ctx context.Context) (repoID, issueID githubv4.ID, err error) {
+	vars := map[string]interface{}{
+		"owner":       githubv4.String(d.Repo.Owner),
+		"name":        githubv4.String(d.Repo.Name),
+		"issueCursor": (*githubv4.String)(nil),
+	}
+	for {
+		var q struct {
+			Repository struct {
+				ID     githubv4.ID
+				Issues struct {
+					Nodes []struct {
+						ID    githubv4.ID
+						Title string
+					}
+					PageInfo prbot.PageInfo
+				} `graphql:"issues(first: 100, after: $issueCursor, states: OPEN, orderBy: {field: CREATED_AT, direction: DESC})"`
+			} `graphql:"repository(owner: $owner, name: $name)"`
+		}
+		err = d.Client.Query(ctx, &q, vars)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot find digest issue in %s: %v", d.Repo, err)
+		}
+
+		for _, issue := range q.Repository.Issues.Nodes {
+			if issue.Title == d.Title {
+				return q.Repository.ID, issue.ID, nil
+			}
+		}
+		if !q.Repository.Issues.PageInfo.HasNextPage {
+			return q.Repository.ID, nil, nil
+		}
+		vars["issueCursor"] = githubv4.NewString(q.Repository.Issues.PageInfo.EndCursor)
+	}
+}
+
+func (d *digestNotifier) createIssue(ctx context.Context, repoID githubv4.ID, body string) (githubv4.ID, error) {
+	var m struct {
+		CreateIssue struct {
+			Issue struct {
+				ID githubv4.ID
+			}
+		} `graphql:"createIssue(input: $input)"`
+	}
+	err := d.Client.Mutate(ctx, &m, githubv4.CreateIssueInput{
+		RepositoryID: repoID,
+		Title:        githubv4.String(d.Title),
+		Body:         githubv4.NewString(githubv4.String(body)),
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create digest issue in %s: %v", d.Repo, err)
+	}
+	return m.CreateIssue.Issue.ID, nil
+}
+
+// renderDigest renders the digest of day as Markdown. Authors are not @-mentioned so that the
+// daily update does not notify them.
+func renderDigest(day string, now time.Time, reports map[string]prbot.Report) string {
+	repos := make([]string, 0, len(reports))
+	for repo := range reports {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Pull request digest of %s, updated daily.\n\n", day)
+	fmt.Fprintln(&b, "| Repository | Open | Approved | Overdue |")
+	fmt.Fprintln(&b, "|---|---:|---:|---:|")
+	for _, repo := range repos {
+		r := reports[repo]
+		fmt.Fprintf(&b, "| %s | %d | %d | %d |\n", repo, len(r.Open), len(r.Approved), len(r.OverdueReview))
+	}
+
+	var overdue bool
+	for _, repo := range repos {
+		for _, pr := range reports[repo].OverdueReview {
+			if !overdue {
+				fmt.Fprintf(&b, "\n## Overdue\n\n")
+				overdue = true
+			}
+			fmt.Fprintf(&b, "- %s#%d [%s](%s) by %s, open for %d days\n", repo, pr.Number, pr.Title, pr.Link(), pr.Author.Login, int(now.Sub(pr.CreatedAt.Time).Hours()/24))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestFindIssuePaginates(t *testing.T) {
+	pages := []string{
+		`{"data": {"repository": {"id": "repo", "issues": {"nodes": [{"id": "other", "title": "Something else"}], "pageInfo": {"endCursor": "c1", "hasNextPage": true}}}}}`,
+		`{"data": {"repository": {"id": "repo", "issues": {"nodes": [{"id": "digest", "title": "PR digest"}], "pageInfo": {"hasNextPage": false}}}}}`,
+	}
+	var cursors []interface{}
+	client := githubv4.NewEnterpriseClient("https://github.test/api/graphql", &http.Client{Transport: roundTripFunc(func(req *http.Request) *http.Response {
+		var body struct {
+			Variables map[string]interface{}
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Errorf("cannot decode request: %v", err)
+		}
+		cursors = append(cursors, body.Variables["issueCursor"])
+		return testResponse(pages[len(cursors)-1], nil)
+	})})
+	d := &digestNotifier{Client: client, Repo: prbot.Repository{Owner: "csweichel", Name: "prbot"}, Title: "PR digest"}
+
+	repoID, issueID, err := d.findIssue(context.Background())
+	if err != nil {
+		t.Fatalf("cannot find issue: %v", err)
+	}
+	if repoID != "repo" || issueID != "digest" {
+		t.Errorf("expected repo/digest, got %v/%v", repoID, issueID)
+	}
+	if len(cursors) != 2 || cursors[0] != nil || cursors[1] != "c1" {
+		t.Errorf("unexpected cursors: %v", cursors)
+	}
+}
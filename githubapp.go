@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// appTokenSource produces GitHub App installation tokens
+type appTokenSource struct {
+	AppID          int64
+	InstallationID int64
+	Key            *rsa.PrivateKey
+	// RESTBaseURL is the base URL of the GitHub REST API, e.g. https://api.github.com
+	RESTBaseURL string
+	Client      *http.Client
+}
+
+// newAppTokenSource returns a token source for the GitHub App configured through GITHUB_APP_ID,
+// GITHUB_APP_INSTALLATION_ID and GITHUB_APP_PRIVATE_KEY_FILE, or nil if GITHUB_APP_ID is not set.
+// Tokens are reused until shortly before they expire and requested through transport.
+func newAppTokenSource(apiURL string, transport http.RoundTripper) (oauth2.TokenSource, error) {
+	appIDEnv := os.Getenv("GITHUB_APP_ID")
+	if appIDEnv == "" {
+		return nil, nil
+	}
+
+	appID, err := strconv.ParseInt(appIDEnv, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse GITHUB_APP_ID: %v", err)
+	}
+	installationID, err := strconv.ParseInt(os.Getenv("GITHUB_APP_INSTALLATION_ID"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse GITHUB_APP_INSTALLATION_ID: %v", err)
+	}
+	keyFile := os.Getenv("GITHUB_APP_PRIVATE_KEY_FILE")
+	if keyFile == "" {
+		return nil, fmt.Errorf("missing GITHUB_APP_PRIVATE_KEY_FILE env var")
+	}
+	key, err := readRSAPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	restBaseURL := "https://api.github.com"
+	if apiURL != "" {
+		// GitHub Enterprise Server serves GraphQL at /api/graphql and REST at /api/v3
+		restBaseURL = strings.TrimSuffix(strings.TrimSuffix(apiURL, "/"), "/graphql") + "/v3"
+	}
+
+	return oauth2.ReuseTokenSource(nil, &appTokenSource{
+		AppID:          appID,
+		InstallationID: installationID,
+		Key:            key,
+		RESTBaseURL:    restBaseURL,
+		Client:         &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}), nil
+}
+
+func readRSAPrivateKey(fn string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read GitHub App private key: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("GitHub App private key %s is not PEM encoded", fn)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse GitHub App private key: %v", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("GitHub App private key %s is not an RSA key", fn)
+	}
+	return rsaKey, nil
+}
+
+// Token exchanges a freshly signed app JWT for an installation token
+func (s *appTokenSource) Token() (*oauth2.Token, error) {
+	jwt, err := s.signJWT(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/app/installations/%d/access_tokens", s.RESTBaseURL, s.InstallationID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot request installation token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("cannot request installation token: %s", resp.Status)
+	}
+
+	var res struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode installation token: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: res.Token,
+		// refresh well before GitHub considers the token expired
+		Expiry: res.ExpiresAt.Add(-5 * time.Minute),
+	}, nil
+}
+
+// signJWT creates the RS256 signed JWT GitHub expects from apps
+func (s *appTokenSource) signJWT(now time.Time) (string, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		// backdate to allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(s.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("cannot sign GitHub App JWT: %v", err)
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthTracker reports healthy once a poll has succeeded, and for as long as the last
+// successful poll is no older than maxAge.
+type healthTracker struct {
+	mu          sync.RWMutex
+	lastSuccess time.Time
+	maxAge      time.Duration
+}
+
+func newHealthTracker(maxAge time.Duration) *healthTracker {
+	return &healthTracker{maxAge: maxAge}
+}
+
+// MarkSuccess records a successful poll
+func (h *healthTracker) MarkSuccess(t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastSuccess = t
+}
+
+func (h *healthTracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.RLock()
+	lastSuccess := h.lastSuccess
+	h.mu.RUnlock()
+
+	if lastSuccess.IsZero() {
+		http.Error(w, "no successful poll yet", http.StatusServiceUnavailable)
+		return
+	}
+	if age := time.Since(lastSuccess); age > h.maxAge {
+		http.Error(w, fmt.Sprintf("last successful poll was %s ago", age.Round(time.Second)), http.StatusServiceUnavailable)
+		return
+	}
+
+	fmt.Fprintf(w, "ok, last successful poll at %s\n", lastSuccess.Format(time.RFC3339))
+}
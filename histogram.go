@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshotHistogramVec is a histogram of the values observed during the most recent poll. Unlike
+// prometheus.HistogramVec it does not accumulate observations: each call to Set replaces the
+// distribution, so re-observing the same open PRs every poll does not inflate the counts.
+type snapshotHistogramVec struct {
+	desc    *prometheus.Desc
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]snapshotHistogramSeries
+}
+
+type snapshotHistogramSeries struct {
+	labelValues []string
+	values      []float64
+}
+
+func newSnapshotHistogramVec(opts prometheus.HistogramOpts, labelNames []string) *snapshotHistogramVec {
+	return &snapshotHistogramVec{
+		desc:    prometheus.NewDesc(prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name), opts.Help, labelNames, nil),
+		buckets: opts.Buckets,
+		series:  make(map[string]snapshotHistogramSeries),
+	}
+}
+
+// Set replaces the observed values of the series identified by lvs
+func (h *snapshotHistogramVec) Set(values []float64, lvs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.series[strings.Join(lvs, "\xff")] = snapshotHistogramSeries{
+		labelValues: lvs,
+		values:      values,
+	}
+}
+
+// Delete deletes the series identified by lvs
+func (h *snapshotHistogramVec) Delete(lvs ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.series, strings.Join(lvs, "\xff"))
+}
+
+func (h *snapshotHistogramVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *snapshotHistogramVec) Collect(ch chan<- prometheus.Metric) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, s := range h.series {
+		var sum float64
+		buckets := make(map[float64]uint64, len(h.buckets))
+		for _, v := range s.values {
+			sum += v
+			for _, b := range h.buckets {
+				if v <= b {
+					buckets[b]++
+				}
+			}
+		}
+		ch <- prometheus.MustNewConstHistogram(h.desc, uint64(len(s.values)), sum, buckets, s.labelValues...)
+	}
+}
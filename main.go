@@ -2,13 +2,23 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"text/tabwriter"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/csweichel/prbot/pkg/prbot"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shurcooL/githubv4"
@@ -16,168 +26,283 @@ import (
 	"golang.org/x/oauth2"
 )
 
-type pullRequest struct {
-	Title  githubv4.String
-	Author struct {
-		Login string
-	}
-	IsDraft   githubv4.Boolean
-	CreatedAt githubv4.GitTimestamp
-	Reviews   struct {
-		TotalCount int
-		Nodes      []struct {
-			State       githubv4.PullRequestReviewState
-			SubmittedAt githubv4.GitTimestamp
-		}
-	} `graphql:"reviews(first: 100)"`
-}
+func main() {
+	once := flag.Bool("once", false, "print the report once and exit instead of serving metrics")
+	detailed := flag.Bool("detailed", false, "list the PRs in each bucket when used with --once")
+	printVersion := flag.Bool("version", false, "print the version and exit")
+	flag.Parse()
+	if *printVersion {
+		fmt.Printf("prbot %s (commit %s, built %s)\n", version, commit, date)
+		return
+	}
+	if os.Getenv("MODE") == "oneshot" {
+		*once = true
+	}
 
-var (
-	pullRequestsCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
-		Namespace: "github",
-		Subsystem: "gitpod_io",
-		Name:      "pull_requests_count",
-	}, []string{"state"})
-)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.WithError(err).Fatal("invalid configuration")
+	}
+	if cfg.LogFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+	level, _ := log.ParseLevel(cfg.LogLevel)
+	log.SetLevel(level)
+	log.WithField("interval", cfg.PollInterval.String()).Info("polling GitHub")
 
-func main() {
-	githubToken := os.Getenv("GITHUB_TOKEN")
-	if len(githubToken) == 0 {
-		log.Fatal("missing GITHUB_TOKEN env var")
+	rand.Seed(time.Now().UnixNano())
+
+	registerMetrics(cfg.MetricsNamespace, cfg.MetricsSubsystem)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.AddHook(pollIDHook{})
+	transport, err := newTransport(cfg.GitHubCACert)
+	if err != nil {
+		log.WithError(err).Fatal("cannot configure TLS")
+	}
+	rateLimits := &rateLimitTransport{
+		Base: &tracingTransport{
+			Base: promhttp.InstrumentRoundTripperDuration(githubRequestDuration, transport),
+		},
+	}
+	auth, err := newAuthTransport(cfg.GitHubAPIURL, transport, &prbot.PreviewTransport{Base: rateLimits})
+	if err != nil {
+		log.WithError(err).Fatal("cannot authenticate with GitHub")
+	}
+	httpClient := &http.Client{Transport: auth}
+	githubClient, err := newGitHubClient(cfg.GitHubAPIURL, httpClient)
+	if err != nil {
+		log.WithError(err).Fatal("invalid GitHub API URL")
+	}
+	reports := newReportStore()
+	pullRequests := newPullRequestStore()
+	health := newHealthTracker(3 * cfg.PollInterval)
+	p := &poller{
+		Client:              githubClient,
+		RateLimits:          rateLimits,
+		Repos:               cfg.repos,
+		Search:              cfg.Search,
+		MaxPages:            cfg.MaxPages,
+		SkipUnchanged:       cfg.SkipUnchanged,
+		SkipUnchangedMaxAge: cfg.SkipUnchangedMaxAge,
+		States:              cfg.prStates,
+		HistoryWindow:       cfg.HistoryWindow,
+		Org:                 cfg.Org,
+		ExcludeRepos:        cfg.excludeRepos,
+		Concurrency:         cfg.Concurrency,
+		Options:             cfg.reportOptions(),
+		Metrics:             cfg.metricsOptions(),
+		OverdueSmoothing:    cfg.OverdueSmoothing,
+		LabelAllowlist:      cfg.LabelAllowlist,
+		RequiredApprovers:   cfg.RequiredApprovers,
+		Interval:            cfg.PollInterval,
+		Timeout:             cfg.PollTimeout,
+		Retry: backoff{
+			MaxAttempts: cfg.Retry.MaxAttempts,
+			BaseDelay:   cfg.Retry.BaseDelay,
+		},
+		Reports:      reports,
+		PullRequests: pullRequests,
+		Health:       health,
+	}
+	// the bucket transition events are an audit trail, hence they go to stdout
+	events := log.New()
+	events.Out = os.Stdout
+	events.Formatter = log.StandardLogger().Formatter
+	p.Events = &bucketLog{Logger: events}
+	if cfg.SlackWebhookURL != "" {
+		p.Slack = newSlackNotifier(cfg.SlackWebhookURL, cfg.notificationTemplate)
+	}
+	if cfg.SnapshotFile != "" {
+		p.Snapshots = &snapshotWriter{Path: cfg.SnapshotFile, MaxBytes: int64(cfg.SnapshotMaxBytes)}
+	}
+	if cfg.digestRepo != nil {
+		p.Digest = &digestNotifier{
+			Client:   githubClient,
+			Repo:     *cfg.digestRepo,
+			Title:    cfg.DigestTitle,
+			Location: cfg.digestLocation,
+		}
+	}
+	if key := os.Getenv("PAGERDUTY_ROUTING_KEY"); key != "" {
+		p.PagerDuty = newPagerDutyNotifier(key, cfg.CriticalOverdueFactor)
 	}
 
-	prometheus.MustRegister(pullRequestsCount)
+	if *once {
+		err := runOnce(ctx, p, os.Stdout, *detailed)
+		if err != nil {
+			log.WithError(err).Fatal("cannot produce report")
+		}
+		return
+	}
+
+	pollerDone := make(chan struct{})
+	go func() {
+		defer close(pollerDone)
+		p.Run(ctx)
+	}()
+
+	ln, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		log.WithError(err).WithField("addr", cfg.ListenAddr).Fatal("cannot listen")
+	}
+	tls := cfg.TLSCertFile != ""
+	if tls {
+		log.Infof("serving metrics at https://%s/metrics", ln.Addr())
+	} else {
+		log.Infof("serving metrics at %s/metrics", ln.Addr())
+	}
 
-	src := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: githubToken},
-	)
-	httpClient := oauth2.NewClient(context.Background(), src)
-	githubClient := githubv4.NewClient(httpClient)
+	authToken := os.Getenv("METRICS_AUTH_TOKEN")
+	mux := http.NewServeMux()
+	// like promhttp.Handler, but clients asking for OpenMetrics get it
+	metrics := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}))
+	mux.Handle("/metrics", requireBearerToken(authToken, metrics))
+	mux.Handle("/report", requireBearerToken(authToken, reports))
+	// debugging only
+	mux.Handle("/prs", requireBearerToken(authToken, pullRequests))
+	mux.Handle("/refresh", requireBearerToken(authToken, http.HandlerFunc(p.serveRefresh)))
+	// health checks stay unauthenticated so that probes keep working
+	mux.Handle("/healthz", health)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		// POST /refresh answers only once a whole poll is done
+		WriteTimeout: 5 * time.Minute,
+		IdleTimeout:  2 * time.Minute,
+	}
 	go func() {
-		t := time.NewTicker(10 * time.Minute)
-		defer t.Stop()
-
-		for {
-			prs, err := getPullRequests(githubClient, "gitpod-io", "gitpod")
-			if err != nil {
-				log.WithError(err).Error("cannot download pull requests")
-				continue
-			}
-
-			updateMetrics(prs)
-			<-t.C
+		<-ctx.Done()
+		log.Info("shutting down")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.WithError(err).Warn("cannot shut down metrics server gracefully")
 		}
 	}()
+	if tls {
+		err = server.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+	} else {
+		err = server.Serve(ln)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.WithError(err).Fatal("cannot serve metrics")
+	}
+	<-pollerDone
+}
 
-	log.Info("serving metrics at :9500/metrics")
+// newTransport returns a clone of http.DefaultTransport, which honors HTTPS_PROXY and friends. If
+// caFile is not empty, the PEM certificates in it are trusted in addition to the system's.
+func newTransport(caFile string) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile == "" {
+		return transport, nil
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(":9500", nil)
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read GITHUB_CA_CERT: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// e.g. on Windows with Go < 1.18
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("GITHUB_CA_CERT %s contains no PEM certificates", caFile)
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return transport, nil
 }
 
-func updateMetrics(prs []pullRequest) error {
-	report := reportWIP(prs)
-	pullRequestsCount.With(prometheus.Labels{
-		"state": "draft",
-	}).Set(float64(len(report.Draft)))
-	pullRequestsCount.With(prometheus.Labels{
-		"state": "approved",
-	}).Set(float64(len(report.Approved)))
-	pullRequestsCount.With(prometheus.Labels{
-		"state": "overdue",
-	}).Set(float64(len(report.OverdueReview)))
-	pullRequestsCount.With(prometheus.Labels{
-		"state": "commented",
-	}).Set(float64(len(report.Commented)))
-	return nil
+// newAuthTransport authenticates requests as GitHub App if one is configured, with the pool of
+// GITHUB_TOKENS if set, and with a single personal access token otherwise. Tokens of the app are
+// requested through transport, all other requests go through base.
+func newAuthTransport(apiURL string, transport, base http.RoundTripper) (http.RoundTripper, error) {
+	src, err := newAppTokenSource(apiURL, transport)
+	if err != nil {
+		return nil, err
+	}
+	if src != nil {
+		return &oauth2.Transport{Source: src, Base: base}, nil
+	}
+
+	if tokens := splitList(os.Getenv("GITHUB_TOKENS")); len(tokens) > 0 {
+		return newTokenPoolTransport(tokens, base), nil
+	}
+
+	token, err := readGitHubToken()
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Transport{Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}), Base: base}, nil
 }
 
-func getPullRequests(client *githubv4.Client, owner, name string) ([]pullRequest, error) {
-	type queryPR struct {
-		Repository struct {
-			PullRequests struct {
-				Nodes    []pullRequest
-				PageInfo struct {
-					EndCursor   githubv4.String
-					HasNextPage bool
-				}
-			} `graphql:"pullRequests(states: OPEN, first: 100, after:$prCursor)"`
-		} `graphql:"repository(owner: $owner, name: $name)"`
-	}
-
-	vars := map[string]interface{}{
-		"owner":    githubv4.String("gitpod-io"),
-		"name":     githubv4.String("gitpod"),
-		"prCursor": (*githubv4.String)(nil),
-	}
-
-	var response []pullRequest
-	for {
-		var q queryPR
-		err := client.Query(context.Background(), &q, vars)
+// readGitHubToken reads the token from the file GITHUB_TOKEN_FILE points to, or from GITHUB_TOKEN
+func readGitHubToken() (string, error) {
+	if fn := os.Getenv("GITHUB_TOKEN_FILE"); fn != "" {
+		b, err := ioutil.ReadFile(fn)
 		if err != nil {
-			return nil, fmt.Errorf("cannot query GitHub: %v", err)
+			return "", fmt.Errorf("cannot read GITHUB_TOKEN_FILE: %v", err)
 		}
-		response = append(response, q.Repository.PullRequests.Nodes...)
-
-		if !q.Repository.PullRequests.PageInfo.HasNextPage {
-			break
+		token := strings.TrimSpace(string(b))
+		if token == "" {
+			return "", fmt.Errorf("GITHUB_TOKEN_FILE %s is empty", fn)
 		}
-		vars["prCursor"] = q.Repository.PullRequests.PageInfo.EndCursor
+		return token, nil
 	}
-	return response, nil
-}
 
-type wipReport struct {
-	Open          []*pullRequest
-	Draft         []*pullRequest
-	Approved      []*pullRequest
-	Commented     []*pullRequest
-	OverdueReview []*pullRequest
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		return "", fmt.Errorf("missing GITHUB_TOKEN or GITHUB_TOKEN_FILE env var")
+	}
+	return token, nil
 }
 
-func reportWIP(prs []pullRequest) wipReport {
-	var res wipReport
-	for _, pr := range prs {
-		pr := pr
-		res.Open = append(res.Open, &pr)
+// newGitHubClient creates a client for github.com, or for the GitHub Enterprise Server GraphQL
+// endpoint apiURL if it is not empty.
+func newGitHubClient(apiURL string, httpClient *http.Client) (*githubv4.Client, error) {
+	if apiURL == "" {
+		return githubv4.NewClient(httpClient), nil
+	}
 
-		if pr.IsDraft {
-			res.Draft = append(res.Draft, &pr)
-			continue
-		}
+	u, err := url.Parse(apiURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %v", apiURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an absolute http(s) URL, e.g. https://github.example.com/api/graphql", apiURL)
+	}
+	return githubv4.NewEnterpriseClient(u.String(), httpClient), nil
+}
 
-		var (
-			lastComment time.Time
-			approved    bool
-		)
-		for _, review := range pr.Reviews.Nodes {
-			if review.State == githubv4.PullRequestReviewStateApproved {
-				approved = true
-			}
-			if review.State == githubv4.PullRequestReviewStateCommented {
-				res.Commented = append(res.Commented, &pr)
-				if lastComment.Before(review.SubmittedAt.Time) {
-					lastComment = review.SubmittedAt.Time
-				}
-			}
+// runOnce fetches the pull requests of all repositories and prints their report to out
+func runOnce(ctx context.Context, p *poller, out io.Writer, detailed bool) error {
+	ctx = withPollID(ctx)
+	res, _, failed := p.fetchAll(ctx)
+	opts := p.reportOptions(ctx)
+	for _, r := range res {
+		if r.Truncated {
+			fmt.Fprintf(out, "%s (truncated, see MAX_PAGES)\n", r.Repo)
+		} else {
+			fmt.Fprintf(out, "%s\n", r.Repo)
 		}
-		if approved {
-			res.Approved = append(res.Approved, &pr)
-		} else if (lastComment.IsZero() && time.Since(pr.CreatedAt.Time) > 24*time.Hour) || (time.Since(lastComment) > 24*time.Hour) {
-			res.OverdueReview = append(res.OverdueReview, &pr)
+		if detailed {
+			prbot.PrintDetailedReport(out, prbot.ReportWIP(r.PRs, opts.ForRepo(r.Repo)))
+		} else {
+			prbot.PrintReport(out, prbot.ReportWIP(r.PRs, opts.ForRepo(r.Repo)))
 		}
+		fmt.Fprintln(out)
 	}
-	return res
-}
-
-func printReport(out io.Writer, r wipReport) {
-	w := &tabwriter.Writer{}
-	w.Init(out, 10, 4, 0, ' ', 0)
-	defer w.Flush()
-
-	fmt.Fprintf(w, "Open:\t%d\n", len(r.Open))
-	fmt.Fprintf(w, "Approved:\t%d\n", len(r.Approved))
-	fmt.Fprintf(w, "Commented:\t%d\n", len(r.Commented))
-	fmt.Fprintf(w, "Overdue:\t%d\n", len(r.OverdueReview))
+	if failed > 0 {
+		return fmt.Errorf("cannot download pull requests of %d repositories", failed)
+	}
+	return nil
 }
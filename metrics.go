@@ -0,0 +1,561 @@
+package main
+
+import (
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/shurcooL/githubv4"
+)
+
+// The metrics about pull requests are named without prefix, registerMetrics prefixes them with the
+// configured namespace and subsystem.
+var (
+	pullRequestsCount = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_count",
+	}, []string{"repo", "base", "state"})
+	pullRequestsByAuthor = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_by_author",
+	}, []string{"repo", "author", "state"})
+	pendingReviewRequests = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pending_review_requests",
+		Help: "Number of open non-draft PRs awaiting a review of the requested reviewer",
+	}, []string{"repo", "reviewer"})
+	pullRequestsByAssignee = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_by_assignee",
+		Help: "Number of open PRs assigned to the assignee. PRs with several assignees count for each of them.",
+	}, []string{"repo", "assignee"})
+	pullRequestsByLabel = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_by_label",
+		Help: "Number of open PRs carrying the label. PRs with several labels count for each of them.",
+	}, []string{"repo", "label"})
+	pullRequestsByMilestone = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_by_milestone",
+		Help: "Number of open PRs per milestone. PRs without milestone count as (none).",
+	}, []string{"repo", "milestone"})
+	pullRequestsWithoutAssignee = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_without_assignee_count",
+		Help: "Number of open PRs without any assignee",
+	}, []string{"repo"})
+	pullRequestsMergeable = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_mergeable",
+		Help: "Number of open non-draft PRs by mergeability. UNKNOWN means GitHub is still computing it.",
+	}, []string{"repo", "state"})
+	pullRequestsMergeState = newSweptGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_merge_state",
+		Help: "Number of open non-draft PRs by merge state status",
+	}, []string{"repo", "state"})
+	pullRequestsWithUnresolvedThreads = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_with_unresolved_threads",
+		Help: "Number of open non-draft PRs with at least one unresolved review thread",
+	}, []string{"repo"})
+	unresolvedReviewThreads = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "unresolved_review_threads",
+		Help: "Number of unresolved review threads, summed over the open non-draft PRs",
+	}, []string{"repo"})
+	pullRequestsReviewDismissed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pull_requests_review_dismissed",
+		Help: "Number of open non-draft PRs whose most recent approving, change-requesting or dismissed review was dismissed",
+	}, []string{"repo"})
+	outstandingChangeRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "outstanding_change_requests",
+		Help: "Number of reviewers whose change request is neither superseded by their approval nor dismissed, summed over the open non-draft PRs",
+	}, []string{"repo"})
+	distinctAuthors = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "distinct_authors_count",
+		Help: "Number of distinct authors with open PRs",
+	}, []string{"repo"})
+	recentReviews = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "recent_reviews_count",
+		Help: "Number of reviews submitted on open PRs within the trailing review window",
+	}, []string{"repo"})
+	lastPollTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "last_poll_timestamp_seconds",
+		Help: "Unix time of the last successful pull request fetch",
+	}, []string{"repo"})
+	pollErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "poll_errors_total",
+		Help: "Number of failed pull request fetches. kind is permission if the token cannot access the repository, and transient otherwise.",
+	}, []string{"repo", "kind"})
+	pollFailing = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "poll_failing",
+		Help: "1 if the last fetch of the repository failed, 0 otherwise",
+	}, []string{"repo"})
+	overdueAverage = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "overdue_moving_average",
+		Help: "Exponentially weighted moving average of the number of overdue PRs across polls, see OVERDUE_SMOOTHING",
+	}, []string{"repo"})
+	overdueTransitionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "overdue_transitions_total",
+		Help: "Number of times a PR became overdue",
+	}, []string{"repo"})
+	oldestOpenPRAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "oldest_open_pr_age_seconds",
+		Help: "Age of the oldest open non-draft PR",
+	}, []string{"repo"})
+	longestWithoutReviewActivity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "longest_without_review_activity_seconds",
+		Help: "Longest time since the last review (or creation if never reviewed) across the open non-draft PRs",
+	}, []string{"repo"})
+	staleDrafts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stale_drafts_count",
+		Help: "Number of draft PRs older than the stale draft threshold",
+	}, []string{"repo"})
+	prAgeHours = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "pr_age_hours",
+		Help:    "Age distribution of the currently open non-draft PRs",
+		Buckets: []float64{1, 6, 24, 72, 168},
+	}, []string{"repo"})
+	timeToFirstReview = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "time_to_first_review_seconds",
+		Help:    "Time from creation to the first review of the currently open, reviewed non-draft PRs",
+		Buckets: []float64{hours(1), hours(4), hours(8), hours(24), hours(72)},
+	}, []string{"repo"})
+	timeToApproval = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "time_to_approval_seconds",
+		Help:    "Time from creation to the first approval of the currently open approved PRs",
+		Buckets: []float64{hours(1), hours(4), hours(8), hours(24), hours(72), hours(168)},
+	}, []string{"repo"})
+	mergedTimeToApproval = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "merged_time_to_approval_seconds",
+		Help:    "Time from creation to the first approval of the approved PRs merged within the history window",
+		Buckets: []float64{hours(1), hours(4), hours(8), hours(24), hours(72), hours(168)},
+	}, []string{"repo"})
+	timeToMerge = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "time_to_merge_seconds",
+		Help:    "Time from creation to merge of the PRs merged within the history window",
+		Buckets: []float64{hours(1), hours(4), hours(8), hours(24), hours(72), hours(168)},
+	}, []string{"repo"})
+	reviewRequestLatency = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "review_request_latency_seconds",
+		Help:    "Time from requesting a user's review to their first review, over the answered requests of the currently open non-draft PRs",
+		Buckets: []float64{hours(1), hours(4), hours(8), hours(24), hours(72)},
+	}, []string{"repo"})
+	reviewsPerPR = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "reviews_per_pr",
+		Help:    "Distribution of the number of reviews of the currently open non-draft PRs, including those without any review",
+		Buckets: []float64{0, 1, 2, 3, 5, 10},
+	}, []string{"repo"})
+	prSizeLines = newSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "pr_size_lines",
+		Help:    "Distribution of lines changed (additions plus deletions) of the currently open non-draft PRs",
+		Buckets: []float64{10, 50, 100, 250, 500, 1000, 5000},
+	}, []string{"repo"})
+	largestOpenPR = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "largest_open_pr_lines",
+		Help: "Lines changed (additions plus deletions) of the largest open non-draft PR",
+	}, []string{"repo"})
+	githubRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "github",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of GitHub API requests",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"code", "method"})
+	rateLimitRemaining = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "github",
+		Name:      "rate_limit_remaining",
+		Help:      "Remaining GraphQL API rate limit budget",
+	})
+	rateLimitLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "github",
+		Name:      "rate_limit_limit",
+		Help:      "GraphQL API rate limit budget per window",
+	})
+	rateLimitReset = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "github",
+		Name:      "rate_limit_reset_timestamp_seconds",
+		Help:      "Unix time at which the GraphQL API rate limit budget resets",
+	})
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "prbot",
+		Name:      "build_info",
+		Help:      "A metric with a constant '1' value labeled by the version, commit and build date prbot was built from",
+	}, []string{"version", "commit", "date"})
+)
+
+// updateRateLimitMetrics records the rate limit budget GitHub reported with a query
+func updateRateLimitMetrics(rl prbot.RateLimit) {
+	rateLimitRemaining.Set(float64(rl.Remaining))
+	rateLimitLimit.Set(float64(rl.Limit))
+	if !rl.ResetAt.IsZero() {
+		rateLimitReset.Set(float64(rl.ResetAt.Unix()))
+	}
+}
+
+// hours returns n hours in seconds
+func hours(n float64) float64 {
+	return n * time.Hour.Seconds()
+}
+
+// registerMetrics registers all metrics with the default registry. Pull request metrics are prefixed
+// with namespace and subsystem, each of which may be empty. The default registry comes with the Go
+// and process collectors, hence registering them again would fail.
+func registerMetrics(namespace, subsystem string) {
+	var prefix string
+	for _, p := range []string{namespace, subsystem} {
+		if p != "" {
+			prefix += p + "_"
+		}
+	}
+	prometheus.WrapRegistererWithPrefix(prefix, prometheus.DefaultRegisterer).MustRegister(
+		pullRequestsCount,
+		pullRequestsByAuthor,
+		pendingReviewRequests,
+		outstandingChangeRequests,
+		pullRequestsReviewDismissed,
+		pullRequestsWithUnresolvedThreads,
+		unresolvedReviewThreads,
+		pullRequestsByAssignee,
+		pullRequestsByLabel,
+		pullRequestsByMilestone,
+		pullRequestsWithoutAssignee,
+		distinctAuthors,
+		recentReviews,
+		pullRequestsMergeable,
+		pullRequestsMergeState,
+		lastPollTimestamp,
+		pollErrorsTotal,
+		pollFailing,
+		overdueTransitionsTotal,
+		overdueAverage,
+		oldestOpenPRAge,
+		longestWithoutReviewActivity,
+		staleDrafts,
+		prAgeHours,
+		timeToFirstReview,
+		timeToApproval,
+		mergedTimeToApproval,
+		timeToMerge,
+		reviewRequestLatency,
+		reviewsPerPR,
+		prSizeLines,
+		largestOpenPR,
+	)
+	prometheus.MustRegister(
+		githubRequestDuration,
+		rateLimitRemaining,
+		rateLimitLimit,
+		rateLimitReset,
+		buildInfo,
+	)
+	buildInfo.WithLabelValues(version, commit, date).Set(1)
+}
+
+// metricsOptions configures the metrics derived from a report
+type metricsOptions struct {
+	// StaleDraftAfter is the age after which a draft PR is considered stale
+	StaleDraftAfter time.Duration
+	// ReviewWindow is the trailing window in which submitted reviews are counted
+	ReviewWindow time.Duration
+}
+
+func updateMetrics(repo prbot.Repository, opts metricsOptions, report prbot.Report) error {
+	states := report.States()
+
+	authors := make(map[string]map[string]int)
+	// base is the branch each PR targets. Every state is reported for each base branch of an open PR,
+	// or for the empty base if there are none, so that empty buckets count zero instead of vanishing.
+	bases := map[string]struct{}{}
+	for _, pr := range append(append([]*prbot.PullRequest{}, report.Open...), report.Draft...) {
+		bases[pr.BaseRefName] = struct{}{}
+	}
+	if len(bases) == 0 {
+		bases[""] = struct{}{}
+	}
+	count := pullRequestsCount.Begin(repo.String())
+	setCounts := func(state string, prs []*prbot.PullRequest) {
+		byBase := make(map[string]int, len(bases))
+		for _, pr := range prs {
+			byBase[pr.BaseRefName]++
+		}
+		for base := range bases {
+			count.Set(float64(byBase[base]), repo.String(), base, state)
+		}
+	}
+	for state, prs := range states {
+		setCounts(state, prs)
+
+		for _, pr := range prs {
+			cnt, ok := authors[pr.Author.Login]
+			if !ok {
+				cnt = make(map[string]int)
+				authors[pr.Author.Login] = cnt
+			}
+			cnt[state]++
+		}
+	}
+
+	// total allows for ratios in PromQL without hardcoding the Open bucket
+	setCounts("total", report.Open)
+	count.End()
+
+	byAuthor := pullRequestsByAuthor.Begin(repo.String())
+	for author, cnt := range authors {
+		for state := range states {
+			byAuthor.Set(float64(cnt[state]), repo.String(), author, state)
+		}
+	}
+	byAuthor.End()
+
+	openAuthors := make(map[string]struct{})
+	for _, pr := range report.Open {
+		openAuthors[pr.Author.Login] = struct{}{}
+	}
+	distinctAuthors.WithLabelValues(repo.String()).Set(float64(len(openAuthors)))
+
+	var reviewCount int
+	windowStart := report.GeneratedAt.Add(-opts.ReviewWindow)
+	for _, pr := range report.Open {
+		for _, review := range pr.Reviews.Nodes {
+			if review.SubmittedAt.After(windowStart) {
+				reviewCount++
+			}
+		}
+	}
+	recentReviews.WithLabelValues(repo.String()).Set(float64(reviewCount))
+
+	reviewers := make(map[string]int)
+	var changeRequests, dismissed, withUnresolved, unresolved int
+	for _, pr := range report.Open {
+		if pr.IsDraft {
+			continue
+		}
+		for _, reviewer := range pr.RequestedReviewers() {
+			reviewers[reviewer]++
+		}
+		changeRequests += len(pr.OutstandingChangeRequests())
+		if pr.LatestReviewDismissed() {
+			dismissed++
+		}
+		if n := pr.UnresolvedReviewThreads(); n > 0 {
+			withUnresolved++
+			unresolved += n
+		}
+	}
+	outstandingChangeRequests.WithLabelValues(repo.String()).Set(float64(changeRequests))
+	pullRequestsReviewDismissed.WithLabelValues(repo.String()).Set(float64(dismissed))
+	pullRequestsWithUnresolvedThreads.WithLabelValues(repo.String()).Set(float64(withUnresolved))
+	unresolvedReviewThreads.WithLabelValues(repo.String()).Set(float64(unresolved))
+	pending := pendingReviewRequests.Begin(repo.String())
+	for reviewer, cnt := range reviewers {
+		pending.Set(float64(cnt), repo.String(), reviewer)
+	}
+	pending.End()
+
+	milestones := make(map[string]int)
+	for _, pr := range report.Open {
+		milestones[pr.MilestoneTitle()]++
+	}
+	byMilestone := pullRequestsByMilestone.Begin(repo.String())
+	for title, cnt := range milestones {
+		byMilestone.Set(float64(cnt), repo.String(), title)
+	}
+	byMilestone.End()
+
+	var withoutAssignee int
+	assignees := make(map[string]int)
+	for _, pr := range report.Open {
+		if len(pr.Assignees.Nodes) == 0 {
+			withoutAssignee++
+		}
+		for _, a := range pr.Assignees.Nodes {
+			assignees[a.Login]++
+		}
+	}
+	byAssignee := pullRequestsByAssignee.Begin(repo.String())
+	for assignee, cnt := range assignees {
+		byAssignee.Set(float64(cnt), repo.String(), assignee)
+	}
+	byAssignee.End()
+	pullRequestsWithoutAssignee.WithLabelValues(repo.String()).Set(float64(withoutAssignee))
+
+	mergeable := map[githubv4.MergeableState]int{
+		githubv4.MergeableStateMergeable:   0,
+		githubv4.MergeableStateConflicting: 0,
+		githubv4.MergeableStateUnknown:     0,
+	}
+	mergeStates := make(map[string]int)
+	for _, pr := range report.Open {
+		if pr.IsDraft {
+			continue
+		}
+		state := pr.Mergeable
+		if state == "" {
+			state = githubv4.MergeableStateUnknown
+		}
+		mergeable[state]++
+		if pr.MergeStateStatus != "" {
+			mergeStates[pr.MergeStateStatus]++
+		}
+	}
+	byMergeable := pullRequestsMergeable.Begin(repo.String())
+	for state, cnt := range mergeable {
+		byMergeable.Set(float64(cnt), repo.String(), string(state))
+	}
+	byMergeable.End()
+	mergeState := pullRequestsMergeState.Begin(repo.String())
+	for state, cnt := range mergeStates {
+		mergeState.Set(float64(cnt), repo.String(), state)
+	}
+	mergeState.End()
+
+	var (
+		oldest           time.Duration
+		neglected        time.Duration
+		ages             []float64
+		firstReviews     []float64
+		requestLatencies []float64
+		reviewCounts     []float64
+		sizes            []float64
+		largest          int
+	)
+	for _, pr := range report.Open {
+		if pr.IsDraft {
+			continue
+		}
+		age := report.GeneratedAt.Sub(pr.CreatedAt.Time)
+		if age > oldest {
+			oldest = age
+		}
+		ages = append(ages, age.Hours())
+
+		lastActivity := pr.LastReviewAt()
+		if lastActivity.IsZero() {
+			lastActivity = pr.CreatedAt.Time
+		}
+		if d := report.GeneratedAt.Sub(lastActivity); d > neglected {
+			neglected = d
+		}
+
+		size := pr.Additions + pr.Deletions
+		if size > largest {
+			largest = size
+		}
+		sizes = append(sizes, float64(size))
+
+		if first := pr.FirstReviewAt(); !first.IsZero() {
+			firstReviews = append(firstReviews, first.Sub(pr.CreatedAt.Time).Seconds())
+		}
+		reviewCounts = append(reviewCounts, float64(pr.Reviews.TotalCount))
+		for _, latency := range pr.ReviewRequestLatencies() {
+			requestLatencies = append(requestLatencies, latency.Seconds())
+		}
+	}
+	oldestOpenPRAge.WithLabelValues(repo.String()).Set(oldest.Seconds())
+	longestWithoutReviewActivity.WithLabelValues(repo.String()).Set(neglected.Seconds())
+	prAgeHours.Set(ages, repo.String())
+	timeToFirstReview.Set(firstReviews, repo.String())
+	reviewRequestLatency.Set(requestLatencies, repo.String())
+	reviewsPerPR.Set(reviewCounts, repo.String())
+	prSizeLines.Set(sizes, repo.String())
+
+	var approvals []float64
+	for _, pr := range report.Approved {
+		if first := pr.FirstApprovalAt(); !first.IsZero() {
+			approvals = append(approvals, first.Sub(pr.CreatedAt.Time).Seconds())
+		}
+	}
+	timeToApproval.Set(approvals, repo.String())
+	largestOpenPR.WithLabelValues(repo.String()).Set(float64(largest))
+
+	var stale int
+	for _, pr := range report.Draft {
+		if report.GeneratedAt.Sub(pr.CreatedAt.Time) > opts.StaleDraftAfter {
+			stale++
+		}
+	}
+	staleDrafts.WithLabelValues(repo.String()).Set(float64(stale))
+
+	return nil
+}
+
+// deleteMetrics deletes all series of repo, e.g. because it is no longer polled
+func deleteMetrics(repo prbot.Repository) {
+	name := repo.String()
+	for _, v := range []*sweptGaugeVec{
+		pullRequestsCount,
+		pullRequestsByAuthor,
+		pendingReviewRequests,
+		pullRequestsByAssignee,
+		pullRequestsByLabel,
+		pullRequestsByMilestone,
+		pullRequestsMergeable,
+		pullRequestsMergeState,
+	} {
+		v.Delete(name)
+	}
+	for _, v := range []*prometheus.GaugeVec{
+		pullRequestsWithoutAssignee,
+		pullRequestsWithUnresolvedThreads,
+		unresolvedReviewThreads,
+		pullRequestsReviewDismissed,
+		outstandingChangeRequests,
+		distinctAuthors,
+		recentReviews,
+		lastPollTimestamp,
+		pollFailing,
+		overdueAverage,
+		oldestOpenPRAge,
+		longestWithoutReviewActivity,
+		staleDrafts,
+		largestOpenPR,
+	} {
+		v.DeleteLabelValues(name)
+	}
+	overdueTransitionsTotal.DeleteLabelValues(name)
+	for _, kind := range []string{"transient", "permission"} {
+		pollErrorsTotal.DeleteLabelValues(name, kind)
+	}
+	for _, h := range []*snapshotHistogramVec{
+		prAgeHours,
+		timeToFirstReview,
+		timeToApproval,
+		mergedTimeToApproval,
+		timeToMerge,
+		reviewRequestLatency,
+		reviewsPerPR,
+		prSizeLines,
+	} {
+		h.Delete(name)
+	}
+}
+
+// updateLabelMetrics counts the open PRs of report per label. If allowlist is not empty, only its
+// labels are counted to bound the number of series.
+func updateLabelMetrics(repo prbot.Repository, report prbot.Report, allowlist []string) {
+	labels := make(map[string]int)
+	for _, pr := range report.Open {
+		for _, l := range pr.Labels.Nodes {
+			labels[l.Name]++
+		}
+	}
+	if len(allowlist) > 0 {
+		allowed := make(map[string]int, len(allowlist))
+		for _, name := range allowlist {
+			allowed[name] = labels[name]
+		}
+		labels = allowed
+	}
+
+	byLabel := pullRequestsByLabel.Begin(repo.String())
+	for name, cnt := range labels {
+		byLabel.Set(float64(cnt), repo.String(), name)
+	}
+	byLabel.End()
+}
+
+// updateMergedMetrics updates the latency metrics of the pull requests merged since since
+func updateMergedMetrics(repo prbot.Repository, prs []prbot.PullRequest, since time.Time) {
+	var approvals, merges []float64
+	for _, pr := range prs {
+		if pr.State != githubv4.PullRequestStateMerged || pr.MergedAt.Time.Before(since) {
+			continue
+		}
+		merges = append(merges, pr.MergedAt.Time.Sub(pr.CreatedAt.Time).Seconds())
+		if first := pr.FirstApprovalAt(); !first.IsZero() {
+			approvals = append(approvals, first.Sub(pr.CreatedAt.Time).Seconds())
+		}
+	}
+	mergedTimeToApproval.Set(approvals, repo.String())
+	timeToMerge.Set(merges, repo.String())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestPullRequestsCountByBase(t *testing.T) {
+	repo := prbot.Repository{Owner: "csweichel", Name: "bases"}
+	pr := func(number int, base string) prbot.PullRequest {
+		var pr prbot.PullRequest
+		pr.Number = githubv4.Int(number)
+		pr.State = githubv4.PullRequestStateOpen
+		pr.BaseRefName = base
+		return pr
+	}
+	report := prbot.ReportWIP([]prbot.PullRequest{pr(1, "main"), pr(2, "main"), pr(3, "release")}, prbot.Options{})
+	updateMetrics(repo, metricsOptions{}, report)
+	defer deleteMetrics(repo)
+
+	for base, exp := range map[string]float64{"main": 2, "release": 1} {
+		if act := testutil.ToFloat64(pullRequestsCount.WithLabelValues(repo.String(), base, "total")); act != exp {
+			t.Errorf("unexpected total of %s: expected %v, got %v", base, exp, act)
+		}
+		if act := testutil.ToFloat64(pullRequestsCount.WithLabelValues(repo.String(), base, "approved")); act != 0 {
+			t.Errorf("expected no approved PRs targeting %s, got %v", base, act)
+		}
+	}
+
+	// once the release PR is gone, so is its series
+	report = prbot.ReportWIP([]prbot.PullRequest{pr(1, "main")}, prbot.Options{})
+	updateMetrics(repo, metricsOptions{}, report)
+	for _, lvs := range pullRequestsCount.known[repo.String()] {
+		if lvs[1] != "main" {
+			t.Errorf("unexpected series %v", lvs)
+		}
+	}
+}
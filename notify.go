@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+)
+
+// defaultNotificationTemplate renders a PR as Slack list item with a link
+const defaultNotificationTemplate = `• <{{.URL}}|#{{.Number}} {{.Title}}> by {{.Author}} (open for {{.AgeHours}}h)`
+
+// notificationData is what notification templates are executed with
+type notificationData struct {
+	Repo     string
+	Number   int
+	Title    string
+	Author   string
+	URL      string
+	AgeHours int
+	Bucket   string
+}
+
+func newNotificationData(repo prbot.Repository, pr *prbot.PullRequest, bucket string) notificationData {
+	return notificationData{
+		Repo:     repo.String(),
+		Number:   int(pr.Number),
+		Title:    string(pr.Title),
+		Author:   pr.Author.Login,
+		URL:      pr.Link(),
+		AgeHours: int(time.Since(pr.CreatedAt.Time).Hours()),
+		Bucket:   bucket,
+	}
+}
+
+// parseNotificationTemplate parses a per-PR notification template and makes sure it can be executed
+func parseNotificationTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse notification template: %v", err)
+	}
+	err = tmpl.Execute(ioutil.Discard, notificationData{
+		Repo:     "gitpod-io/gitpod",
+		Number:   1,
+		Title:    "Example",
+		Author:   "octocat",
+		URL:      "https://github.com/gitpod-io/gitpod/pull/1",
+		AgeHours: 1,
+		Bucket:   "overdue",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot execute notification template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// renderNotification renders pr with tmpl
+func renderNotification(tmpl *template.Template, repo prbot.Repository, pr *prbot.PullRequest, bucket string) (string, error) {
+	var res strings.Builder
+	err := tmpl.Execute(&res, newNotificationData(repo, pr, bucket))
+	if err != nil {
+		return "", fmt.Errorf("cannot render notification of #%d: %v", pr.Number, err)
+	}
+	return res.String(), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+)
+
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyNotifier triggers a PagerDuty incident for each critically overdue PR, i.e. one that
+// waits for review for Factor times the overdue threshold, and resolves it once the PR leaves that
+// state.
+type pagerDutyNotifier struct {
+	RoutingKey string
+	Factor     int
+	Client     *http.Client
+
+	mu sync.Mutex
+	// triggered contains the numbers of the PRs with an incident per repository
+	triggered map[string]map[int]struct{}
+}
+
+func newPagerDutyNotifier(routingKey string, factor int) *pagerDutyNotifier {
+	return &pagerDutyNotifier{
+		RoutingKey: routingKey,
+		Factor:     factor,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		triggered:  make(map[string]map[int]struct{}),
+	}
+}
+
+// Notify triggers incidents for the critically overdue PRs of report without one, and resolves the
+// incidents of PRs which are no longer critically overdue. Incidents are deduplicated by PR, hence
+// triggering again after a restart is harmless. Failed events are retried at the next call.
+func (n *pagerDutyNotifier) Notify(ctx context.Context, repo prbot.Repository, opts prbot.Options, report prbot.Report) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	threshold := time.Duration(n.Factor) * opts.OverdueAfter
+	critical := make(map[int]*prbot.PullRequest)
+	for _, pr := range report.OverdueReview {
+		if opts.IsOverdue(pr, threshold) {
+			critical[int(pr.Number)] = pr
+		}
+	}
+
+	triggered, ok := n.triggered[repo.String()]
+	if !ok {
+		triggered = make(map[int]struct{})
+		n.triggered[repo.String()] = triggered
+	}
+
+	var firstErr error
+	for nr, pr := range critical {
+		if _, ok := triggered[nr]; ok {
+			continue
+		}
+		err := n.send(ctx, pagerDutyEvent{
+			RoutingKey:  n.RoutingKey,
+			EventAction: "trigger",
+			DedupKey:    dedupKey(repo, nr),
+			Payload: &pagerDutyPayload{
+				Summary:  fmt.Sprintf("%s#%d %q by %s is waiting for review for more than %s", repo, nr, pr.Title, pr.Author.Login, threshold),
+				Source:   repo.String(),
+				Severity: "warning",
+				CustomDetails: map[string]string{
+					"url":    pr.Link(),
+					"author": pr.Author.Login,
+				},
+			},
+			Links: []pagerDutyLink{{Href: pr.Link(), Text: fmt.Sprintf("%s#%d", repo, nr)}},
+		})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		triggered[nr] = struct{}{}
+	}
+	for nr := range triggered {
+		if _, ok := critical[nr]; ok {
+			continue
+		}
+		err := n.send(ctx, pagerDutyEvent{
+			RoutingKey:  n.RoutingKey,
+			EventAction: "resolve",
+			DedupKey:    dedupKey(repo, nr),
+		})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		delete(triggered, nr)
+	}
+	return firstErr
+}
+
+func dedupKey(repo prbot.Repository, number int) string {
+	return fmt.Sprintf("prbot/%s#%d", repo, number)
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+func (n *pagerDutyNotifier) send(ctx context.Context, evt pagerDutyEvent) error {
+	body, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pagerDutyEventsURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create PagerDuty request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot send PagerDuty event: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("cannot send PagerDuty event: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package prbot
+
+import (
+	"fmt"
+	"time"
+)
+
+// BusinessHours measures elapsed time during working hours only, Monday to Friday
+type BusinessHours struct {
+	Location *time.Location
+	// Start and End are the minutes after midnight the working day starts and ends at
+	Start int
+	End   int
+}
+
+// ParseBusinessHours parses working hours like 09:00-17:00 in the time zone tz
+func ParseBusinessHours(hours, tz string) (*BusinessHours, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %v", tz, err)
+	}
+
+	var sh, sm, eh, em int
+	_, err = fmt.Sscanf(hours, "%d:%d-%d:%d", &sh, &sm, &eh, &em)
+	if err != nil {
+		return nil, fmt.Errorf("invalid business hours %q: expected HH:MM-HH:MM", hours)
+	}
+	start, end := sh*60+sm, eh*60+em
+	if sh < 0 || sm < 0 || sm > 59 || em < 0 || em > 59 || start >= end || end > 24*60 {
+		return nil, fmt.Errorf("invalid business hours %q: expected HH:MM-HH:MM with the start before the end", hours)
+	}
+	return &BusinessHours{Location: loc, Start: start, End: end}, nil
+}
+
+// Elapsed returns the working time between from and to
+func (b *BusinessHours) Elapsed(from, to time.Time) time.Duration {
+	if !to.After(from) {
+		return 0
+	}
+
+	from, to = from.In(b.Location), to.In(b.Location)
+	var total time.Duration
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, b.Location); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if wd := day.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			continue
+		}
+
+		// time.Date instead of day.Add so that working hours stay put on DST changes
+		start := time.Date(day.Year(), day.Month(), day.Day(), 0, b.Start, 0, 0, b.Location)
+		end := time.Date(day.Year(), day.Month(), day.Day(), 0, b.End, 0, 0, b.Location)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			total += end.Sub(start)
+		}
+	}
+	return total
+}
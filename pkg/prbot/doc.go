@@ -0,0 +1,8 @@
+// Package prbot downloads the open pull requests of GitHub repositories and sorts them into
+// buckets by their review state, e.g. approved or overdue.
+//
+// GetPullRequests and SearchPullRequests download pull requests through the GitHub GraphQL API.
+// Clients should send their requests through PreviewTransport, which enables the schema previews
+// some of the fields rely on. ReportWIP then classifies the pull requests into a Report according
+// to Options, and PrintReport and PrintDetailedReport render it for humans.
+package prbot
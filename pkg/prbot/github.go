@@ -0,0 +1,819 @@
+package prbot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+	log "github.com/sirupsen/logrus"
+)
+
+// RateLimit is the GraphQL rate limit budget as reported by GitHub
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	ResetAt   githubv4.DateTime
+}
+
+// PageInfo is the pagination state of a GraphQL connection
+type PageInfo struct {
+	EndCursor   githubv4.String
+	HasNextPage bool
+}
+
+// PullRequestReview is a review of a pull request
+type PullRequestReview struct {
+	Author struct {
+		Login string
+	}
+	State       githubv4.PullRequestReviewState
+	SubmittedAt githubv4.GitTimestamp
+}
+
+// NoMilestone is the milestone title of pull requests without milestone
+const NoMilestone = "(none)"
+
+// PullRequest is a pull request as downloaded by GetPullRequests and SearchPullRequests
+type PullRequest struct {
+	ID     githubv4.ID
+	Number githubv4.Int
+	URL    githubv4.URI
+	Title  githubv4.String
+	Author struct {
+		Login string
+	}
+	Repository struct {
+		NameWithOwner string
+	}
+	// IsCrossRepository is true for PRs from forks
+	IsCrossRepository bool
+	IsDraft           githubv4.Boolean
+	CreatedAt         githubv4.GitTimestamp
+	UpdatedAt         githubv4.GitTimestamp
+	State             githubv4.PullRequestState
+	// MergedAt is zero unless the pull request is merged
+	MergedAt    githubv4.DateTime
+	BaseRefName string
+	Milestone   *struct {
+		Title string
+	}
+	Additions int
+	Deletions int
+	Mergeable githubv4.MergeableState
+	// MergeStateStatus is still a schema preview and hence not typed by githubv4
+	MergeStateStatus string
+	Reviews          struct {
+		TotalCount int
+		Nodes      []PullRequestReview
+		PageInfo   PageInfo
+	} `graphql:"reviews(first: 100)"`
+	Commits struct {
+		Nodes []struct {
+			Commit struct {
+				CommittedDate     githubv4.GitTimestamp
+				StatusCheckRollup *struct {
+					State githubv4.StatusState
+				}
+			}
+		}
+	} `graphql:"commits(last: 1)"`
+	ReviewRequests struct {
+		Nodes []struct {
+			RequestedReviewer struct {
+				User struct {
+					Login string
+				} `graphql:"... on User"`
+				Team struct {
+					CombinedSlug string
+				} `graphql:"... on Team"`
+			}
+		}
+	} `graphql:"reviewRequests(first: 50)"`
+	Labels struct {
+		Nodes []struct {
+			Name string
+		}
+	} `graphql:"labels(first: 20)"`
+	Assignees struct {
+		Nodes []struct {
+			Login string
+		}
+	} `graphql:"assignees(first: 10)"`
+	TimelineItems struct {
+		Nodes []struct {
+			ReviewRequestedEvent struct {
+				CreatedAt         githubv4.DateTime
+				RequestedReviewer struct {
+					User struct {
+						Login string
+					} `graphql:"... on User"`
+				}
+			} `graphql:"... on ReviewRequestedEvent"`
+		}
+	} `graphql:"timelineItems(itemTypes: [REVIEW_REQUESTED_EVENT], first: 50)"`
+	ReviewThreads struct {
+		Nodes    []PullRequestReviewThread
+		PageInfo PageInfo
+	} `graphql:"reviewThreads(first: 100)"`
+}
+
+// PullRequestReviewThread is a conversation on the diff of a pull request
+type PullRequestReviewThread struct {
+	IsResolved bool
+}
+
+// FirstReviewAt returns the time the earliest submitted review was submitted, or the zero time if
+// the PR has not been reviewed yet
+func (pr *PullRequest) FirstReviewAt() time.Time {
+	return pr.earliestReview(func(PullRequestReview) bool { return true })
+}
+
+// FirstApprovalAt returns the time of the earliest approving review, or the zero time if the PR
+// has not been approved. Later approvals, e.g. after re-reviews, are ignored.
+func (pr *PullRequest) FirstApprovalAt() time.Time {
+	return pr.earliestReview(func(r PullRequestReview) bool { return r.State == githubv4.PullRequestReviewStateApproved })
+}
+
+// ReviewRequestLatencies returns, for each review request of a user that was answered, the time
+// until that user submitted their first review after being requested. Requests of teams cannot be
+// attributed to a review and are ignored, as are requests that were not answered yet.
+func (pr *PullRequest) ReviewRequestLatencies() []time.Duration {
+	var res []time.Duration
+	for _, item := range pr.TimelineItems.Nodes {
+		req := item.ReviewRequestedEvent
+		login := req.RequestedReviewer.User.Login
+		if login == "" {
+			continue
+		}
+
+		var first time.Time
+		for _, review := range pr.Reviews.Nodes {
+			t := review.SubmittedAt.Time
+			if review.Author.Login != login || !t.After(req.CreatedAt.Time) {
+				continue
+			}
+			if first.IsZero() || t.Before(first) {
+				first = t
+			}
+		}
+		if !first.IsZero() {
+			res = append(res, first.Sub(req.CreatedAt.Time))
+		}
+	}
+	return res
+}
+
+// LastReviewAt returns the time of the most recent submitted review of any state, or the zero
+// time if there is none
+func (pr *PullRequest) LastReviewAt() time.Time {
+	var last time.Time
+	for _, review := range pr.Reviews.Nodes {
+		if review.SubmittedAt.After(last) {
+			last = review.SubmittedAt.Time
+		}
+	}
+	return last
+}
+
+// earliestReview returns the submission time of the earliest submitted review matching pred
+func (pr *PullRequest) earliestReview(pred func(PullRequestReview) bool) time.Time {
+	var first time.Time
+	for _, review := range pr.Reviews.Nodes {
+		t := review.SubmittedAt.Time
+		if t.IsZero() {
+			// pending reviews have not been submitted
+			continue
+		}
+		if !pred(review) {
+			continue
+		}
+		if first.IsZero() || t.Before(first) {
+			first = t
+		}
+	}
+	return first
+}
+
+// CIState returns the combined check state of the latest commit, or an empty state if it has no checks
+func (pr *PullRequest) CIState() githubv4.StatusState {
+	if len(pr.Commits.Nodes) == 0 {
+		return ""
+	}
+	rollup := pr.Commits.Nodes[0].Commit.StatusCheckRollup
+	if rollup == nil {
+		return ""
+	}
+	return rollup.State
+}
+
+// Link returns the URL of the PR, or an empty string if it is unknown
+func (pr *PullRequest) Link() string {
+	if pr.URL.URL == nil {
+		return ""
+	}
+	return pr.URL.String()
+}
+
+// LastCommitAt returns the commit date of the PR's latest commit, or the zero time if it is unknown
+func (pr *PullRequest) LastCommitAt() time.Time {
+	if len(pr.Commits.Nodes) == 0 {
+		return time.Time{}
+	}
+	return pr.Commits.Nodes[0].Commit.CommittedDate.Time
+}
+
+// RequestedReviewers returns the logins of users and the org/slug of teams whose review was requested
+func (pr *PullRequest) RequestedReviewers() []string {
+	var res []string
+	for _, req := range pr.ReviewRequests.Nodes {
+		reviewer := req.RequestedReviewer
+		switch {
+		case reviewer.User.Login != "":
+			res = append(res, reviewer.User.Login)
+		case reviewer.Team.CombinedSlug != "":
+			res = append(res, reviewer.Team.CombinedSlug)
+		}
+	}
+	return res
+}
+
+// LatestReviewStates returns the state of each reviewer's most recent approving, change-requesting
+// or dismissed review, keyed by login. Comments neither approve nor withdraw a change request, hence
+// they are ignored.
+func (pr *PullRequest) LatestReviewStates() map[string]githubv4.PullRequestReviewState {
+	res := make(map[string]githubv4.PullRequestReviewState)
+	for login, review := range pr.latestReviews() {
+		res[login] = review.State
+	}
+	return res
+}
+
+// LatestReviewDismissed returns true if the most recent approving, change-requesting or dismissed
+// review of pr was dismissed, e.g. because new commits were pushed after an approval
+func (pr *PullRequest) LatestReviewDismissed() bool {
+	var latest *PullRequestReview
+	for _, review := range pr.latestReviews() {
+		review := review
+		if latest == nil || review.SubmittedAt.Time.After(latest.SubmittedAt.Time) {
+			latest = &review
+		}
+	}
+	return latest != nil && latest.State == githubv4.PullRequestReviewStateDismissed
+}
+
+// latestReviews returns each reviewer's most recent approving, change-requesting or dismissed
+// review, keyed by login
+func (pr *PullRequest) latestReviews() map[string]PullRequestReview {
+	res := make(map[string]PullRequestReview)
+	for _, review := range pr.Reviews.Nodes {
+		switch review.State {
+		case githubv4.PullRequestReviewStateApproved, githubv4.PullRequestReviewStateChangesRequested, githubv4.PullRequestReviewStateDismissed:
+		default:
+			continue
+		}
+		login := review.Author.Login
+		if prev, ok := res[login]; ok && !review.SubmittedAt.Time.After(prev.SubmittedAt.Time) {
+			continue
+		}
+		res[login] = review
+	}
+	return res
+}
+
+// OutstandingChangeRequests returns the logins of the reviewers who requested changes and have
+// neither approved since nor had their review dismissed
+func (pr *PullRequest) OutstandingChangeRequests() []string {
+	var res []string
+	for login, state := range pr.LatestReviewStates() {
+		if state == githubv4.PullRequestReviewStateChangesRequested {
+			res = append(res, login)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// UnresolvedReviewThreads returns the number of review threads of pr which are not resolved
+func (pr *PullRequest) UnresolvedReviewThreads() int {
+	var res int
+	for _, t := range pr.ReviewThreads.Nodes {
+		if !t.IsResolved {
+			res++
+		}
+	}
+	return res
+}
+
+// MilestoneTitle returns the title of the milestone of pr, or NoMilestone if it has none
+func (pr *PullRequest) MilestoneTitle() string {
+	if pr.Milestone == nil {
+		return NoMilestone
+	}
+	return pr.Milestone.Title
+}
+
+// HasLabel returns true if the pull request carries the label name
+func (pr *PullRequest) HasLabel(name string) bool {
+	for _, l := range pr.Labels.Nodes {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Deduplicate drops all but the first occurrence of each pull request in prs. Paginating over
+// results which change in between, e.g. because a pull request was updated, can return a pull
+// request twice.
+func Deduplicate(prs []PullRequest) []PullRequest {
+	type key struct {
+		Repo   string
+		Number githubv4.Int
+	}
+	var (
+		res  = make([]PullRequest, 0, len(prs))
+		seen = make(map[key]struct{}, len(prs))
+	)
+	for _, pr := range prs {
+		k := key{Repo: pr.Repository.NameWithOwner, Number: pr.Number}
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		res = append(res, pr)
+	}
+	return res
+}
+
+// FetchOptions controls which pull requests GetPullRequests and SearchPullRequests download. The
+// latter ignores States and Since, which are up to its query.
+type FetchOptions struct {
+	// MaxPages bounds the number of pages of pull requests downloaded per group of states. If it is
+	// not positive, all pages are downloaded.
+	MaxPages int
+	// States are the states of the pull requests to download, only OPEN if empty
+	States []githubv4.PullRequestState
+	// Since skips closed and merged pull requests last updated before it. Open pull requests are
+	// always downloaded.
+	Since time.Time
+	// OnRateLimit is called with the GraphQL rate limit budget after each query, if set
+	OnRateLimit func(RateLimit)
+}
+
+func (opts FetchOptions) onRateLimit(rl RateLimit) {
+	if opts.OnRateLimit != nil {
+		opts.OnRateLimit(rl)
+	}
+}
+
+// GetPullRequests downloads the pull requests of owner/name in the states of opts. It stops after
+// opts.MaxPages pages of pull requests and reports whether it did so.
+func GetPullRequests(ctx context.Context, client *githubv4.Client, owner, name string, opts FetchOptions) (prs []PullRequest, truncated bool, err error) {
+	states := opts.States
+	if len(states) == 0 {
+		states = []githubv4.PullRequestState{githubv4.PullRequestStateOpen}
+	}
+	// only the closed and merged pull requests are bounded by opts.Since, hence they are downloaded
+	// separately from the open ones, and in a different order
+	var open, done []githubv4.PullRequestState
+	for _, s := range states {
+		if s == githubv4.PullRequestStateOpen {
+			open = append(open, s)
+		} else {
+			done = append(done, s)
+		}
+	}
+	if len(open) > 0 {
+		prs, truncated, err = getPullRequests(ctx, client, owner, name, open, false, time.Time{}, opts)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if len(done) > 0 {
+		res, t, err := getPullRequests(ctx, client, owner, name, done, true, opts.Since, opts)
+		if err != nil {
+			return nil, false, err
+		}
+		prs = append(prs, res...)
+		truncated = truncated || t
+	}
+	return Deduplicate(prs), truncated, nil
+}
+
+// pullRequestsPage is a page of the pull requests of a repository
+type pullRequestsPage struct {
+	Nodes    []PullRequest
+	PageInfo PageInfo
+}
+
+// queryOpenPR pages through pull requests in GitHub's default order, i.e. by creation. Unlike the
+// update order it does not change while paginating, hence no pull request is skipped because it
+// moved ahead of the cursor.
+type queryOpenPR struct {
+	RateLimit  RateLimit
+	Repository struct {
+		PullRequests pullRequestsPage `graphql:"pullRequests(states: $states, first: 100, after: $prCursor)"`
+	} `graphql:"repository(owner: $owner, name: $name)"`
+}
+
+// queryHistoryPR pages through pull requests most recently updated first, so that paging can stop
+// at a cut-off
+type queryHistoryPR struct {
+	RateLimit  RateLimit
+	Repository struct {
+		PullRequests pullRequestsPage `graphql:"pullRequests(states: $states, first: 100, after: $prCursor, orderBy: {field: UPDATED_AT, direction: DESC})"`
+	} `graphql:"repository(owner: $owner, name: $name)"`
+}
+
+// getPullRequests downloads the pull requests of owner/name in states. If history is set, they are
+// downloaded most recently updated first until one was last updated before since, otherwise all of
+// them are downloaded.
+func getPullRequests(ctx context.Context, client *githubv4.Client, owner, name string, states []githubv4.PullRequestState, history bool, since time.Time, opts FetchOptions) (prs []PullRequest, truncated bool, err error) {
+	vars := map[string]interface{}{
+		"owner":    githubv4.String(owner),
+		"name":     githubv4.String(name),
+		"prCursor": (*githubv4.String)(nil),
+		"states":   states,
+	}
+
+	var response []PullRequest
+	for page := 1; ; page++ {
+		var (
+			conn      pullRequestsPage
+			rateLimit RateLimit
+		)
+		if history {
+			var q queryHistoryPR
+			err = client.Query(ctx, &q, vars)
+			conn, rateLimit = q.Repository.PullRequests, q.RateLimit
+		} else {
+			var q queryOpenPR
+			err = client.Query(ctx, &q, vars)
+			conn, rateLimit = q.Repository.PullRequests, q.RateLimit
+		}
+		if isPartialResponse(err, len(conn.Nodes)) {
+			log.WithContext(ctx).WithError(err).WithField("repo", owner+"/"+name).Warn("GitHub returned partial data, continuing with what we got")
+		} else if err != nil {
+			return nil, false, fmt.Errorf("cannot query GitHub: %v", err)
+		}
+		opts.onRateLimit(rateLimit)
+		err = completePullRequests(ctx, client, conn.Nodes)
+		if err != nil {
+			return nil, false, err
+		}
+		var reachedSince bool
+		for _, pr := range conn.Nodes {
+			if pr.ID == nil {
+				// null in a partial response
+				continue
+			}
+			if history && pr.UpdatedAt.Time.Before(since) {
+				reachedSince = true
+				break
+			}
+			response = append(response, pr)
+		}
+
+		if reachedSince || !conn.PageInfo.HasNextPage {
+			break
+		}
+		if opts.MaxPages > 0 && page >= opts.MaxPages {
+			log.WithContext(ctx).WithField("repo", owner+"/"+name).WithField("maxPages", opts.MaxPages).Warn("too many pull requests, results are truncated")
+			return response, true, nil
+		}
+		vars["prCursor"] = conn.PageInfo.EndCursor
+	}
+	return response, false, nil
+}
+
+// PullRequestsProbe summarizes the open pull requests of a repository. If it did not change between
+// two polls, neither did the pull requests.
+type PullRequestsProbe struct {
+	TotalCount int
+	UpdatedAt  time.Time
+}
+
+// ProbePullRequests fetches the number of open pull requests of owner/name and when the most
+// recently updated one was updated, which is much cheaper than GetPullRequests
+func ProbePullRequests(ctx context.Context, client *githubv4.Client, owner, name string) (PullRequestsProbe, error) {
+	var q struct {
+		Repository struct {
+			PullRequests struct {
+				TotalCount int
+				Nodes      []struct {
+					UpdatedAt githubv4.GitTimestamp
+				}
+			} `graphql:"pullRequests(states: OPEN, first: 1, orderBy: {field: UPDATED_AT, direction: DESC})"`
+		} `graphql:"repository(owner: $owner, name: $name)"`
+	}
+	vars := map[string]interface{}{
+		"owner": githubv4.String(owner),
+		"name":  githubv4.String(name),
+	}
+	err := client.Query(ctx, &q, vars)
+	if err != nil {
+		return PullRequestsProbe{}, fmt.Errorf("cannot probe pull requests: %v", err)
+	}
+
+	res := PullRequestsProbe{TotalCount: q.Repository.PullRequests.TotalCount}
+	if len(q.Repository.PullRequests.Nodes) > 0 {
+		res.UpdatedAt = q.Repository.PullRequests.Nodes[0].UpdatedAt.Time
+	}
+	return res, nil
+}
+
+// ListOrgRepositories lists the repositories of the organization org, skipping archived ones
+func ListOrgRepositories(ctx context.Context, client *githubv4.Client, org string) ([]Repository, error) {
+	type queryRepos struct {
+		Organization struct {
+			Repositories struct {
+				Nodes []struct {
+					Name       string
+					IsArchived bool
+				}
+				PageInfo PageInfo
+			} `graphql:"repositories(first: 100, after: $repoCursor)"`
+		} `graphql:"organization(login: $org)"`
+	}
+
+	vars := map[string]interface{}{
+		"org":        githubv4.String(org),
+		"repoCursor": (*githubv4.String)(nil),
+	}
+
+	var res []Repository
+	for {
+		var q queryRepos
+		err := client.Query(ctx, &q, vars)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list repositories of %s: %v", org, err)
+		}
+		for _, r := range q.Organization.Repositories.Nodes {
+			if r.IsArchived {
+				continue
+			}
+			res = append(res, Repository{Owner: org, Name: r.Name})
+		}
+
+		if !q.Organization.Repositories.PageInfo.HasNextPage {
+			break
+		}
+		vars["repoCursor"] = q.Organization.Repositories.PageInfo.EndCursor
+	}
+	return res, nil
+}
+
+// ListTeamMembers lists the logins of the members of the team org/slug, including those of its
+// child teams
+func ListTeamMembers(ctx context.Context, client *githubv4.Client, org, slug string) ([]string, error) {
+	type queryMembers struct {
+		Organization struct {
+			Team struct {
+				Slug    string
+				Members struct {
+					Nodes []struct {
+						Login string
+					}
+					PageInfo PageInfo
+				} `graphql:"members(first: 100, after: $memberCursor)"`
+			} `graphql:"team(slug: $slug)"`
+		} `graphql:"organization(login: $org)"`
+	}
+
+	vars := map[string]interface{}{
+		"org":          githubv4.String(org),
+		"slug":         githubv4.String(slug),
+		"memberCursor": (*githubv4.String)(nil),
+	}
+
+	var res []string
+	for {
+		var q queryMembers
+		err := client.Query(ctx, &q, vars)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list members of %s/%s: %v", org, slug, err)
+		}
+		if q.Organization.Team.Slug == "" {
+			return nil, fmt.Errorf("team %s/%s does not exist or is not visible to the token", org, slug)
+		}
+		for _, m := range q.Organization.Team.Members.Nodes {
+			res = append(res, m.Login)
+		}
+
+		if !q.Organization.Team.Members.PageInfo.HasNextPage {
+			break
+		}
+		vars["memberCursor"] = q.Organization.Team.Members.PageInfo.EndCursor
+	}
+	return res, nil
+}
+
+// SearchPullRequests downloads all pull requests matching the GitHub search query. Issues matching
+// the query are ignored. It stops after opts.MaxPages pages of results, unless that is not positive,
+// and reports whether it did so.
+func SearchPullRequests(ctx context.Context, client *githubv4.Client, query string, opts FetchOptions) (prs []PullRequest, truncated bool, err error) {
+	type querySearch struct {
+		RateLimit RateLimit
+		Search    struct {
+			Nodes []struct {
+				PullRequest PullRequest `graphql:"... on PullRequest"`
+			}
+			PageInfo PageInfo
+		} `graphql:"search(query: $query, type: ISSUE, first: 100, after: $searchCursor)"`
+	}
+
+	vars := map[string]interface{}{
+		"query":        githubv4.String(query),
+		"searchCursor": (*githubv4.String)(nil),
+	}
+
+	var response []PullRequest
+	for page := 1; ; page++ {
+		var q querySearch
+		err := client.Query(ctx, &q, vars)
+		if isPartialResponse(err, len(q.Search.Nodes)) {
+			log.WithContext(ctx).WithError(err).WithField("search", query).Warn("GitHub returned partial data, continuing with what we got")
+		} else if err != nil {
+			return nil, false, fmt.Errorf("cannot search GitHub: %v", err)
+		}
+		opts.onRateLimit(q.RateLimit)
+		var prs []PullRequest
+		for _, node := range q.Search.Nodes {
+			if node.PullRequest.ID == nil {
+				// not a pull request
+				continue
+			}
+			prs = append(prs, node.PullRequest)
+		}
+		err = completePullRequests(ctx, client, prs)
+		if err != nil {
+			return nil, false, err
+		}
+		response = append(response, prs...)
+
+		if !q.Search.PageInfo.HasNextPage {
+			break
+		}
+		if opts.MaxPages > 0 && page >= opts.MaxPages {
+			log.WithContext(ctx).WithField("search", query).WithField("maxPages", opts.MaxPages).Warn("too many search results, results are truncated")
+			return Deduplicate(response), true, nil
+		}
+		vars["searchCursor"] = q.Search.PageInfo.EndCursor
+	}
+	return Deduplicate(response), false, nil
+}
+
+// maxConcurrentPagination bounds how many pull requests completePullRequests completes at once
+const maxConcurrentPagination = 4
+
+// completePullRequests completes the pull requests whose reviews or review threads did not fit the
+// first page. The pages of a connection depend on each other's cursors, hence the pull requests,
+// and the reviews and review threads of each, are completed concurrently instead. It returns the
+// first error encountered.
+func completePullRequests(ctx context.Context, client *githubv4.Client, prs []PullRequest) error {
+	var (
+		sem  = make(chan struct{}, maxConcurrentPagination)
+		wg   sync.WaitGroup
+		errs = make([]error, len(prs))
+	)
+	for i := range prs {
+		pr := &prs[i]
+		if !pr.Reviews.PageInfo.HasNextPage && !pr.ReviewThreads.PageInfo.HasNextPage {
+			continue
+		}
+
+		i := i
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			errs[i] = completePullRequest(ctx, client, pr)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// completePullRequest downloads the reviews and review threads of pr beyond the first page. Both
+// are downloaded concurrently as they touch distinct fields of pr.
+func completePullRequest(ctx context.Context, client *githubv4.Client, pr *PullRequest) error {
+	var (
+		wg        sync.WaitGroup
+		threadErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		threadErr = getRemainingReviewThreads(ctx, client, pr)
+	}()
+	err := getRemainingReviews(ctx, client, pr)
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	return threadErr
+}
+
+// getRemainingReviews downloads the reviews of pr beyond the first page fetched by GetPullRequests
+func getRemainingReviews(ctx context.Context, client *githubv4.Client, pr *PullRequest) error {
+	type queryReviews struct {
+		Node struct {
+			PullRequest struct {
+				Reviews struct {
+					Nodes    []PullRequestReview
+					PageInfo PageInfo
+				} `graphql:"reviews(first: 100, after: $reviewCursor)"`
+			} `graphql:"... on PullRequest"`
+		} `graphql:"node(id: $id)"`
+	}
+
+	vars := map[string]interface{}{
+		"id":           pr.ID,
+		"reviewCursor": pr.Reviews.PageInfo.EndCursor,
+	}
+	for pr.Reviews.PageInfo.HasNextPage {
+		var q queryReviews
+		err := client.Query(ctx, &q, vars)
+		if err != nil {
+			return fmt.Errorf("cannot query reviews of \"%s\": %v", pr.Title, err)
+		}
+
+		reviews := q.Node.PullRequest.Reviews
+		pr.Reviews.Nodes = append(pr.Reviews.Nodes, reviews.Nodes...)
+		pr.Reviews.PageInfo = reviews.PageInfo
+		vars["reviewCursor"] = reviews.PageInfo.EndCursor
+	}
+	return nil
+}
+
+// getRemainingReviewThreads downloads the review threads of pr beyond the first page fetched by
+// GetPullRequests
+func getRemainingReviewThreads(ctx context.Context, client *githubv4.Client, pr *PullRequest) error {
+	type queryThreads struct {
+		Node struct {
+			PullRequest struct {
+				ReviewThreads struct {
+					Nodes    []PullRequestReviewThread
+					PageInfo PageInfo
+				} `graphql:"reviewThreads(first: 100, after: $threadCursor)"`
+			} `graphql:"... on PullRequest"`
+		} `graphql:"node(id: $id)"`
+	}
+
+	vars := map[string]interface{}{
+		"id":           pr.ID,
+		"threadCursor": pr.ReviewThreads.PageInfo.EndCursor,
+	}
+	for pr.ReviewThreads.PageInfo.HasNextPage {
+		var q queryThreads
+		err := client.Query(ctx, &q, vars)
+		if err != nil {
+			return fmt.Errorf("cannot query review threads of \"%s\": %v", pr.Title, err)
+		}
+
+		threads := q.Node.PullRequest.ReviewThreads
+		pr.ReviewThreads.Nodes = append(pr.ReviewThreads.Nodes, threads.Nodes...)
+		pr.ReviewThreads.PageInfo = threads.PageInfo
+		vars["threadCursor"] = threads.PageInfo.EndCursor
+	}
+	return nil
+}
+
+// isPartialResponse returns true if err was reported alongside the data of a GraphQL response which
+// still contained nodes. Transport failures and responses without any data are not partial.
+func isPartialResponse(err error, nodes int) bool {
+	return err != nil && nodes > 0 && isGraphQLError(err)
+}
+
+// isGraphQLError returns true if err are the errors of a GraphQL response, as opposed to a transport
+// failure. The GraphQL client does not export its error type, hence we identify it by name.
+func isGraphQLError(err error) bool {
+	t := reflect.TypeOf(err)
+	return t != nil && t.PkgPath() == "github.com/shurcooL/graphql" && t.Name() == "errors"
+}
+
+// PreviewTransport opts into the GraphQL schema previews prbot depends on
+type PreviewTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *PreviewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	// required for PullRequest.mergeStateStatus
+	req.Header.Add("Accept", "application/vnd.github.merge-info-preview+json")
+	return t.Base.RoundTrip(req)
+}
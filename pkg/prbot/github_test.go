@@ -0,0 +1,322 @@
+package prbot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+// graphQLRequest is a request received by a fake GraphQL server
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// newTestClient returns a client of a fake GraphQL server which responds to each request with the
+// JSON body returned by handle
+func newTestClient(t *testing.T, handle func(req graphQLRequest) string) *githubv4.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphQLRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, handle(req))
+	}))
+	t.Cleanup(srv.Close)
+	return githubv4.NewEnterpriseClient(srv.URL, srv.Client())
+}
+
+func TestCompletePullRequestsConcurrently(t *testing.T) {
+	const n = 10
+
+	// the last page of the reviews of each PR is only returned once the last page of its review
+	// threads was requested, which deadlocks unless both are downloaded concurrently
+	var (
+		mu      sync.Mutex
+		threads = make(map[string]chan struct{}, n)
+	)
+	threadsRequested := func(id string) chan struct{} {
+		mu.Lock()
+		defer mu.Unlock()
+		if threads[id] == nil {
+			threads[id] = make(chan struct{})
+		}
+		return threads[id]
+	}
+
+	client := newTestClient(t, func(req graphQLRequest) string {
+		id := req.Variables["id"].(string)
+		if cursor, ok := req.Variables["threadCursor"]; ok {
+			close(threadsRequested(id))
+			return fmt.Sprintf(`{"data": {"node": {"reviewThreads": {
+				"nodes": [{"isResolved": false}],
+				"pageInfo": {"endCursor": "%s", "hasNextPage": false}
+			}}}}`, cursor)
+		}
+
+		select {
+		case <-threadsRequested(id):
+		case <-time.After(5 * time.Second):
+			return `{"errors": [{"message": "review threads were not requested concurrently"}]}`
+		}
+		return fmt.Sprintf(`{"data": {"node": {"reviews": {
+			"nodes": [{"author": {"login": "%s"}, "state": "APPROVED", "submittedAt": "2021-06-01T12:00:00Z"}],
+			"pageInfo": {"endCursor": "r2", "hasNextPage": false}
+		}}}}`, id)
+	})
+
+	prs := make([]PullRequest, n)
+	for i := range prs {
+		pr := &prs[i]
+		pr.ID = fmt.Sprintf("pr%d", i)
+		pr.Reviews.PageInfo = PageInfo{EndCursor: "r1", HasNextPage: true}
+		pr.ReviewThreads.PageInfo = PageInfo{EndCursor: "t1", HasNextPage: true}
+	}
+	// already complete, must not be queried
+	prs = append(prs, PullRequest{ID: "complete"})
+
+	err := completePullRequests(context.Background(), client, prs)
+	if err != nil {
+		t.Fatalf("cannot complete pull requests: %v", err)
+	}
+	for _, pr := range prs[:n] {
+		if len(pr.Reviews.Nodes) != 1 || pr.Reviews.Nodes[0].Author.Login != pr.ID {
+			t.Errorf("%s: unexpected reviews %+v", pr.ID, pr.Reviews.Nodes)
+		}
+		if len(pr.ReviewThreads.Nodes) != 1 || pr.ReviewThreads.PageInfo.HasNextPage {
+			t.Errorf("%s: unexpected review threads %+v", pr.ID, pr.ReviewThreads)
+		}
+	}
+	if len(prs[n].Reviews.Nodes) != 0 {
+		t.Errorf("queried already complete pull request")
+	}
+}
+
+// emptyPullRequestsPage is a response of the pull requests query without any pull requests
+const emptyPullRequestsPage = `{"data": {"repository": {"pullRequests": {"nodes": [], "pageInfo": {"hasNextPage": false}}}}}`
+
+func TestGetPullRequestsVariables(t *testing.T) {
+	var reqs []graphQLRequest
+	client := newTestClient(t, func(req graphQLRequest) string {
+		reqs = append(reqs, req)
+		return emptyPullRequestsPage
+	})
+
+	_, _, err := GetPullRequests(context.Background(), client, "csweichel", "prbot", FetchOptions{})
+	if err != nil {
+		t.Fatalf("cannot get pull requests: %v", err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("expected a single request, got %d", len(reqs))
+	}
+	for name, exp := range map[string]string{"owner": "csweichel", "name": "prbot"} {
+		if act := reqs[0].Variables[name]; act != exp {
+			t.Errorf("unexpected %s: expected %q, got %v", name, exp, act)
+		}
+	}
+}
+
+// testReviewsJSON returns the JSON of n reviews by distinct reviewers in state, starting at reviewer first
+func testReviewsJSON(first, n int, state githubv4.PullRequestReviewState) string {
+	reviews := make([]string, n)
+	for i := range reviews {
+		reviews[i] = fmt.Sprintf(`{"author": {"login": "reviewer%d"}, "state": "%s", "submittedAt": "2021-06-01T10:00:00Z"}`, first+i, state)
+	}
+	return strings.Join(reviews, ",")
+}
+
+func TestGetPullRequestsPaginatesReviews(t *testing.T) {
+	client := newTestClient(t, func(req graphQLRequest) string {
+		if _, ok := req.Variables["reviewCursor"]; ok {
+			if req.Variables["reviewCursor"] != "page1" {
+				return `{"errors": [{"message": "unexpected cursor"}]}`
+			}
+			// the approval is the very last review
+			return fmt.Sprintf(`{"data": {"node": {"reviews": {
+				"nodes": [%s, %s],
+				"pageInfo": {"endCursor": "page2", "hasNextPage": false}
+			}}}}`, testReviewsJSON(100, 49, githubv4.PullRequestReviewStateCommented), testReviewsJSON(149, 1, githubv4.PullRequestReviewStateApproved))
+		}
+		return fmt.Sprintf(`{"data": {"repository": {"pullRequests": {
+			"nodes": [{
+				"id": "pr1", "number": 1, "state": "OPEN", "createdAt": "2021-06-01T00:00:00Z",
+				"reviews": {"totalCount": 150, "nodes": [%s], "pageInfo": {"endCursor": "page1", "hasNextPage": true}}
+			}],
+			"pageInfo": {"hasNextPage": false}
+		}}}}`, testReviewsJSON(0, 100, githubv4.PullRequestReviewStateCommented))
+	})
+
+	prs, _, err := GetPullRequests(context.Background(), client, "csweichel", "prbot", FetchOptions{})
+	if err != nil {
+		t.Fatalf("cannot get pull requests: %v", err)
+	}
+	if len(prs) != 1 {
+		t.Fatalf("expected 1 pull request, got %d", len(prs))
+	}
+	if n := len(prs[0].Reviews.Nodes); n != 150 {
+		t.Errorf("expected 150 reviews, got %d", n)
+	}
+	r := ReportWIP(prs, testOptions())
+	if len(r.Approved) != 1 {
+		t.Errorf("expected the approval on the second page to count")
+	}
+}
+
+func TestGetPullRequestsPartialResponse(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Response string
+		PRs      int
+		Err      bool
+	}{
+		{
+			Name: "partial data",
+			Response: `{
+				"data": {"repository": {"pullRequests": {"nodes": [{"id": "pr1", "number": 1}, null], "pageInfo": {"hasNextPage": false}}}},
+				"errors": [{"message": "Something went wrong while executing your query.", "path": ["repository", "pullRequests", "nodes", 1]}]
+			}`,
+			PRs: 1,
+		},
+		{
+			Name:     "no data",
+			Response: `{"data": null, "errors": [{"message": "Something went wrong while executing your query."}]}`,
+			Err:      true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			client := newTestClient(t, func(graphQLRequest) string { return test.Response })
+			prs, _, err := GetPullRequests(context.Background(), client, "csweichel", "prbot", FetchOptions{})
+			if (err != nil) != test.Err {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(prs) != test.PRs {
+				t.Errorf("expected %d pull requests, got %d", test.PRs, len(prs))
+			}
+		})
+	}
+}
+
+func TestMaxPages(t *testing.T) {
+	const pages = 5
+
+	// pageJSON returns page i of pages, counting from 1, each containing pull request #i
+	pageJSON := func(req graphQLRequest, cursor string) string {
+		page := 1
+		if c, ok := req.Variables[cursor].(string); ok {
+			fmt.Sscanf(c, "page%d", &page)
+			page++
+		}
+		return fmt.Sprintf(`"nodes": [{"id": "pr%d", "number": %d}],
+			"pageInfo": {"endCursor": "page%d", "hasNextPage": %v}`, page, page, page, page < pages)
+	}
+	client := newTestClient(t, func(req graphQLRequest) string {
+		if _, ok := req.Variables["query"]; ok {
+			return fmt.Sprintf(`{"data": {"search": {%s}}}`, pageJSON(req, "searchCursor"))
+		}
+		return fmt.Sprintf(`{"data": {"repository": {"pullRequests": {%s}}}}`, pageJSON(req, "prCursor"))
+	})
+
+	tests := []struct {
+		MaxPages  int
+		PRs       int
+		Truncated bool
+	}{
+		{MaxPages: 3, PRs: 3, Truncated: true},
+		{MaxPages: pages, PRs: pages},
+		{MaxPages: 0, PRs: pages},
+		{MaxPages: -1, PRs: pages},
+	}
+	for _, test := range tests {
+		test := test
+		opts := FetchOptions{MaxPages: test.MaxPages}
+		for name, fetch := range map[string]func() ([]PullRequest, bool, error){
+			"repository": func() ([]PullRequest, bool, error) {
+				return GetPullRequests(context.Background(), client, "csweichel", "prbot", opts)
+			},
+			"search": func() ([]PullRequest, bool, error) {
+				return SearchPullRequests(context.Background(), client, "is:pr is:open", opts)
+			},
+		} {
+			t.Run(fmt.Sprintf("%s/%d", name, test.MaxPages), func(t *testing.T) {
+				prs, truncated, err := fetch()
+				if err != nil {
+					t.Fatalf("cannot get pull requests: %v", err)
+				}
+				if len(prs) != test.PRs {
+					t.Errorf("expected %d pull requests, got %d", test.PRs, len(prs))
+				}
+				if truncated != test.Truncated {
+					t.Errorf("unexpected truncated: expected %v, got %v", test.Truncated, truncated)
+				}
+			})
+		}
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	pr := func(repo string, number int, title string) PullRequest {
+		var pr PullRequest
+		pr.Repository.NameWithOwner = repo
+		pr.Number = githubv4.Int(number)
+		pr.Title = githubv4.String(title)
+		return pr
+	}
+	prs := Deduplicate([]PullRequest{
+		pr("csweichel/prbot", 1, "first"),
+		pr("csweichel/prbot", 2, "second"),
+		pr("csweichel/prbot", 1, "first, updated while paginating"),
+		pr("csweichel/other", 1, "other repository"),
+		pr("csweichel/prbot", 2, "second, updated while paginating"),
+	})
+
+	var titles []string
+	for _, pr := range prs {
+		titles = append(titles, string(pr.Title))
+	}
+	exp := []string{"first", "second", "other repository"}
+	if !reflect.DeepEqual(titles, exp) {
+		t.Errorf("unexpected pull requests: expected %v, got %v", exp, titles)
+	}
+}
+
+func TestGetPullRequestsOrder(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		queries = make(map[string]string)
+	)
+	client := newTestClient(t, func(req graphQLRequest) string {
+		mu.Lock()
+		defer mu.Unlock()
+		states := fmt.Sprint(req.Variables["states"])
+		queries[states] = req.Query
+		return emptyPullRequestsPage
+	})
+
+	_, _, err := GetPullRequests(context.Background(), client, "csweichel", "prbot", FetchOptions{
+		States: []githubv4.PullRequestState{githubv4.PullRequestStateOpen, githubv4.PullRequestStateMerged},
+		Since:  testNow.Add(-24 * time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("cannot get pull requests: %v", err)
+	}
+	// the update order changes while paginating, which would skip open pull requests
+	if q := queries["[OPEN]"]; q == "" || strings.Contains(q, "UPDATED_AT") {
+		t.Errorf("open pull requests must be downloaded in the default order, got query %q", q)
+	}
+	if q := queries["[MERGED]"]; !strings.Contains(q, "orderBy: {field: UPDATED_AT, direction: DESC}") {
+		t.Errorf("merged pull requests must be downloaded most recently updated first, got query %q", q)
+	}
+}
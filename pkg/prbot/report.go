@@ -0,0 +1,491 @@
+package prbot
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+	log "github.com/sirupsen/logrus"
+)
+
+// Report buckets open pull requests by their review state. The buckets are not exclusive:
+// a non-draft PR can be both commented and overdue.
+type Report struct {
+	// GeneratedAt is the time the PRs were classified at, see Options.Now
+	GeneratedAt time.Time
+	// Open contains all PRs, except drafts if Options.OpenExcludesDrafts is set
+	Open []*PullRequest
+	// Draft contains all draft PRs. Drafts are in no other bucket but (by default) Open.
+	Draft []*PullRequest
+	// Approved contains PRs with at least one reviewer whose latest review approves, and none whose
+	// latest review requests changes
+	Approved []*PullRequest
+	// ApprovedByRequired contains approved PRs with at least one approval of a required approver,
+	// see Options.RequiredApprovers
+	ApprovedByRequired []*PullRequest
+	// ApprovedCIFailing contains approved PRs whose latest commit has failing or errored checks
+	ApprovedCIFailing []*PullRequest
+	// ApprovedStale contains approved PRs whose most recent approval is older than the
+	// approved-stale threshold, i.e. they sit unmerged for too long
+	ApprovedStale []*PullRequest
+	// ChangesRequested contains PRs with at least one reviewer whose latest review requests
+	// changes, i.e. who neither approved since nor had their review dismissed
+	ChangesRequested []*PullRequest
+	// BlockedOnAuthor contains the ChangesRequested PRs without a commit since the most recent
+	// change request which is still outstanding
+	BlockedOnAuthor []*PullRequest
+	// Commented contains PRs with at least one review in one of Options.CommentedStates
+	Commented []*PullRequest
+	// OverdueReview contains PRs which are not approved and whose last comment (or creation if
+	// they have no comments) is older than the overdue threshold
+	OverdueReview []*PullRequest
+	// AwaitingAuthor contains PRs which are not approved and whose most recent review is a comment
+	// of someone other than the author older than the awaiting-author threshold, i.e. the author
+	// has not responded for too long
+	AwaitingAuthor []*PullRequest
+	// Unassigned contains non-draft PRs without any review and without requested reviewers, i.e.
+	// nobody is on the hook for them
+	Unassigned []*PullRequest
+}
+
+// States returns the buckets besides Open by the state name they are reported with
+func (r Report) States() map[string][]*PullRequest {
+	return map[string][]*PullRequest{
+		"draft":                r.Draft,
+		"approved":             r.Approved,
+		"approved_by_required": r.ApprovedByRequired,
+		"approved_ci_failing":  r.ApprovedCIFailing,
+		"approved_stale":       r.ApprovedStale,
+		"changes_requested":    r.ChangesRequested,
+		"blocked_on_author":    r.BlockedOnAuthor,
+		"overdue":              r.OverdueReview,
+		"commented":            r.Commented,
+		"awaiting_author":      r.AwaitingAuthor,
+		"unassigned":           r.Unassigned,
+	}
+}
+
+// BucketsByNumber returns the sorted states of each PR in the report, keyed by PR number
+func (r Report) BucketsByNumber() map[int][]string {
+	res := make(map[int][]string, len(r.Open))
+	for _, pr := range r.Open {
+		res[int(pr.Number)] = nil
+	}
+	for state, prs := range r.States() {
+		for _, pr := range prs {
+			res[int(pr.Number)] = append(res[int(pr.Number)], state)
+		}
+	}
+	for _, states := range res {
+		sort.Strings(states)
+	}
+	return res
+}
+
+// Options configure ReportWIP
+type Options struct {
+	// OverdueAfter is the time without review activity after which a non-approved PR is overdue
+	OverdueAfter time.Duration
+	// OverdueAfterByRepo overrides OverdueAfter for individual repositories, see ForRepo
+	OverdueAfterByRepo map[string]time.Duration
+	// BusinessHours makes OverdueAfter count working hours only. If nil, wall-clock time is used.
+	BusinessHours *BusinessHours
+	// AwaitingAuthorAfter is the age of a trailing review comment after which a PR is awaiting its author
+	AwaitingAuthorAfter time.Duration
+	// ApprovedStaleAfter is the age of the most recent approval after which an approved PR is stale
+	ApprovedStaleAfter time.Duration
+	// FilterLabel restricts the report to PRs carrying this label. If empty, all PRs are considered.
+	FilterLabel string
+	// BaseBranch restricts the report to PRs targeting this branch. If empty, all PRs are considered.
+	BaseBranch string
+	// Milestone restricts the report to PRs of the milestone with this title, or to those without
+	// milestone if it is NoMilestone. If empty, all PRs are considered.
+	Milestone string
+	// OpenExcludesDrafts keeps drafts out of the Open bucket
+	OpenExcludesDrafts bool
+	// IgnoreForks skips PRs from forks entirely
+	IgnoreForks bool
+	// IgnoreAuthors are glob patterns (see path.Match) of author logins whose PRs are skipped entirely
+	IgnoreAuthors []string
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+	// CommentedStates are the review states which count as comments towards the Commented,
+	// OverdueReview and AwaitingAuthor buckets. If empty, only COMMENTED does.
+	CommentedStates []githubv4.PullRequestReviewState
+	// MinAge keeps PRs younger than it out of the Overdue and Unassigned buckets
+	MinAge time.Duration
+	// RequiredApprovers are the logins whose approval puts an approved PR into ApprovedByRequired.
+	// If nil, any approval does, if empty, none does.
+	RequiredApprovers []string
+	// Logger receives the debug log of how each PR was classified, e.g. log.WithContext(ctx). If
+	// nil, the standard logger is used.
+	Logger *log.Entry
+}
+
+// ForRepo returns the options with the overrides of repo applied
+func (opts Options) ForRepo(repo Repository) Options {
+	if d, ok := opts.OverdueAfterByRepo[repo.String()]; ok {
+		opts.OverdueAfter = d
+	}
+	return opts
+}
+
+// Elapsed returns the time between from and to which counts towards OverdueAfter
+func (opts Options) Elapsed(from, to time.Time) time.Duration {
+	if opts.BusinessHours == nil {
+		return to.Sub(from)
+	}
+	return opts.BusinessHours.Elapsed(from, to)
+}
+
+func (opts Options) logger() *log.Entry {
+	if opts.Logger == nil {
+		return log.NewEntry(log.StandardLogger())
+	}
+	return opts.Logger
+}
+
+func (opts Options) now() time.Time {
+	if opts.Now == nil {
+		return time.Now()
+	}
+	return opts.Now()
+}
+
+// isIgnoredAuthor returns true if login matches any of the IgnoreAuthors patterns
+func (opts Options) isIgnoredAuthor(login string) bool {
+	for _, pattern := range opts.IgnoreAuthors {
+		if ok, _ := path.Match(pattern, login); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isCommentState returns true if reviews in state count as comments
+func (opts Options) isCommentState(state githubv4.PullRequestReviewState) bool {
+	if len(opts.CommentedStates) == 0 {
+		return state == githubv4.PullRequestReviewStateCommented
+	}
+	for _, s := range opts.CommentedStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequiredApprover returns true if login may approve PRs into ApprovedByRequired
+func (opts Options) isRequiredApprover(login string) bool {
+	if opts.RequiredApprovers == nil {
+		return true
+	}
+	for _, approver := range opts.RequiredApprovers {
+		if strings.EqualFold(approver, login) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportWIP sorts the open pull requests into buckets. Closed and merged ones are ignored.
+func ReportWIP(prs []PullRequest, opts Options) Report {
+	now := opts.now()
+	// classify all PRs at the same instant
+	opts.Now = func() time.Time { return now }
+	res := Report{GeneratedAt: now}
+	for _, pr := range prs {
+		pr := pr
+		if pr.State != githubv4.PullRequestStateOpen {
+			continue
+		}
+		if opts.FilterLabel != "" && !pr.HasLabel(opts.FilterLabel) {
+			continue
+		}
+		if opts.BaseBranch != "" && pr.BaseRefName != opts.BaseBranch {
+			continue
+		}
+		if opts.Milestone != "" && pr.MilestoneTitle() != opts.Milestone {
+			continue
+		}
+		if opts.isIgnoredAuthor(pr.Author.Login) {
+			continue
+		}
+		if opts.IgnoreForks && pr.IsCrossRepository {
+			continue
+		}
+		c := classifyPR(&pr, opts)
+		if !c.Draft || !opts.OpenExcludesDrafts {
+			res.Open = append(res.Open, &pr)
+		}
+		if c.Draft {
+			res.Draft = append(res.Draft, &pr)
+		}
+		if c.Approved {
+			res.Approved = append(res.Approved, &pr)
+		}
+		if c.ApprovedByRequired {
+			res.ApprovedByRequired = append(res.ApprovedByRequired, &pr)
+		}
+		if c.ApprovedCIFailing {
+			res.ApprovedCIFailing = append(res.ApprovedCIFailing, &pr)
+		}
+		if c.ApprovedStale {
+			res.ApprovedStale = append(res.ApprovedStale, &pr)
+		}
+		if c.ChangesRequested {
+			res.ChangesRequested = append(res.ChangesRequested, &pr)
+		}
+		if c.BlockedOnAuthor {
+			res.BlockedOnAuthor = append(res.BlockedOnAuthor, &pr)
+		}
+		if c.Commented {
+			res.Commented = append(res.Commented, &pr)
+		}
+		if c.Overdue {
+			res.OverdueReview = append(res.OverdueReview, &pr)
+		}
+		if c.AwaitingAuthor {
+			res.AwaitingAuthor = append(res.AwaitingAuthor, &pr)
+		}
+		if c.Unassigned {
+			res.Unassigned = append(res.Unassigned, &pr)
+		}
+
+		opts.logger().WithFields(log.Fields{
+			"title":       string(pr.Title),
+			"buckets":     c.buckets(),
+			"createdAt":   pr.CreatedAt.Format(time.RFC3339),
+			"lastComment": formatTime(c.LastComment),
+		}).Debug("classified PR")
+	}
+	return res
+}
+
+// classification describes which buckets a PR belongs to, and why
+type classification struct {
+	Draft    bool
+	Approved bool
+	// ApprovedByRequired is true for approved PRs which a required approver approved
+	ApprovedByRequired bool
+	// ApprovedCIFailing is true for approved PRs whose checks fail
+	ApprovedCIFailing bool
+	// ApprovedStale is true for approved PRs whose last approval is older than the threshold
+	ApprovedStale    bool
+	ChangesRequested bool
+	// BlockedOnAuthor is true if changes were requested and no commit landed since
+	BlockedOnAuthor bool
+	Commented       bool
+	Overdue         bool
+	AwaitingAuthor  bool
+	Unassigned      bool
+
+	// LastComment is the time of the most recent review in one of Options.CommentedStates, or
+	// zero if there is none
+	LastComment time.Time
+	// LastApproval is the time of the most recent approving review, or zero if there is none
+	LastApproval time.Time
+}
+
+func (c classification) buckets() []string {
+	var res []string
+	for _, b := range []struct {
+		Name   string
+		Member bool
+	}{
+		{"draft", c.Draft},
+		{"approved", c.Approved},
+		{"approved_by_required", c.ApprovedByRequired},
+		{"approved_ci_failing", c.ApprovedCIFailing},
+		{"approved_stale", c.ApprovedStale},
+		{"changes_requested", c.ChangesRequested},
+		{"blocked_on_author", c.BlockedOnAuthor},
+		{"commented", c.Commented},
+		{"overdue", c.Overdue},
+		{"awaiting_author", c.AwaitingAuthor},
+		{"unassigned", c.Unassigned},
+	} {
+		if b.Member {
+			res = append(res, b.Name)
+		}
+	}
+	return res
+}
+
+// classifyPR decides which buckets pr belongs to. Each PR is added to a bucket at most once,
+// no matter how many reviews it has.
+func classifyPR(pr *PullRequest, opts Options) (res classification) {
+	now := opts.now()
+	if pr.IsDraft {
+		res.Draft = true
+		return res
+	}
+	// brand-new PRs don't need attention yet
+	young := now.Sub(pr.CreatedAt.Time) < opts.MinAge
+	res.Unassigned = !young && pr.Reviews.TotalCount == 0 && len(pr.ReviewRequests.Nodes) == 0
+
+	// the author answering in a review of their own hands the ball back to the reviewers
+	var lastChangesRequest, lastReviewerComment, lastAuthorReply time.Time
+	for _, review := range pr.Reviews.Nodes {
+		switch review.State {
+		case githubv4.PullRequestReviewStateApproved:
+			if res.LastApproval.Before(review.SubmittedAt.Time) {
+				res.LastApproval = review.SubmittedAt.Time
+			}
+		case githubv4.PullRequestReviewStateChangesRequested:
+			if lastChangesRequest.Before(review.SubmittedAt.Time) {
+				lastChangesRequest = review.SubmittedAt.Time
+			}
+		}
+		if opts.isCommentState(review.State) {
+			res.Commented = true
+			if res.LastComment.Before(review.SubmittedAt.Time) {
+				res.LastComment = review.SubmittedAt.Time
+			}
+			if review.Author.Login == pr.Author.Login {
+				if lastAuthorReply.Before(review.SubmittedAt.Time) {
+					lastAuthorReply = review.SubmittedAt.Time
+				}
+			} else if lastReviewerComment.Before(review.SubmittedAt.Time) {
+				lastReviewerComment = review.SubmittedAt.Time
+			}
+		}
+	}
+
+	// like on GitHub, only the latest review of each reviewer counts, and a single change request
+	// blocks any number of approvals
+	var lastOutstandingChangeRequest time.Time
+	for login, review := range pr.latestReviews() {
+		switch review.State {
+		case githubv4.PullRequestReviewStateApproved:
+			res.Approved = true
+			res.ApprovedByRequired = res.ApprovedByRequired || opts.isRequiredApprover(login)
+		case githubv4.PullRequestReviewStateChangesRequested:
+			res.ChangesRequested = true
+			if lastOutstandingChangeRequest.Before(review.SubmittedAt.Time) {
+				lastOutstandingChangeRequest = review.SubmittedAt.Time
+			}
+		}
+	}
+	if res.ChangesRequested {
+		res.Approved = false
+		res.ApprovedByRequired = false
+	}
+	if res.ChangesRequested {
+		// change requests superseded by an approval of the same reviewer don't block
+		res.BlockedOnAuthor = pr.LastCommitAt().Before(lastOutstandingChangeRequest)
+	}
+	if res.Approved {
+		ci := pr.CIState()
+		res.ApprovedCIFailing = ci == githubv4.StatusStateFailure || ci == githubv4.StatusStateError
+		res.ApprovedStale = now.Sub(res.LastApproval) > opts.ApprovedStaleAfter
+		return res
+	}
+
+	res.Overdue = !young && isOverdue(pr, res.LastComment, now, opts.OverdueAfter, opts.Elapsed)
+
+	// the ball is in the author's court if a reviewer's comment is the latest review
+	if !lastReviewerComment.IsZero() && !lastReviewerComment.Before(res.LastApproval) && !lastReviewerComment.Before(lastChangesRequest) && lastAuthorReply.Before(lastReviewerComment) {
+		res.AwaitingAuthor = now.Sub(lastReviewerComment) > opts.AwaitingAuthorAfter
+	}
+	return res
+}
+
+// IsOverdue returns true if pr has been waiting for review activity for longer than threshold, as
+// measured by Elapsed. A PR without review in one of CommentedStates waits since its creation, all
+// others since their last such review.
+func (opts Options) IsOverdue(pr *PullRequest, threshold time.Duration) bool {
+	return isOverdue(pr, opts.LastCommentAt(pr), opts.now(), threshold, opts.Elapsed)
+}
+
+// LastCommentAt returns the time of the most recent review of pr in one of CommentedStates, or the
+// zero time if there is none
+func (opts Options) LastCommentAt(pr *PullRequest) time.Time {
+	var last time.Time
+	for _, review := range pr.Reviews.Nodes {
+		if opts.isCommentState(review.State) && last.Before(review.SubmittedAt.Time) {
+			last = review.SubmittedAt.Time
+		}
+	}
+	return last
+}
+
+func isOverdue(pr *PullRequest, lastComment, now time.Time, threshold time.Duration, elapsed func(from, to time.Time) time.Duration) bool {
+	if lastComment.IsZero() {
+		// never commented: the zero time would make every PR overdue
+		return elapsed(pr.CreatedAt.Time, now) > threshold
+	}
+	return elapsed(lastComment, now) > threshold
+}
+
+// formatTime formats t as RFC3339, or returns "never" for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// PrintReport prints the number of PRs in each bucket of r
+func PrintReport(out io.Writer, r Report) {
+	w := &tabwriter.Writer{}
+	w.Init(out, 10, 4, 0, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Open:\t%d\n", len(r.Open))
+	fmt.Fprintf(w, "Approved:\t%d\n", len(r.Approved))
+	fmt.Fprintf(w, "Approved by required approver:\t%d\n", len(r.ApprovedByRequired))
+	fmt.Fprintf(w, "Approved, CI failing:\t%d\n", len(r.ApprovedCIFailing))
+	fmt.Fprintf(w, "Approved, stale:\t%d\n", len(r.ApprovedStale))
+	fmt.Fprintf(w, "Changes requested:\t%d\n", len(r.ChangesRequested))
+	fmt.Fprintf(w, "Blocked on author:\t%d\n", len(r.BlockedOnAuthor))
+	fmt.Fprintf(w, "Commented:\t%d\n", len(r.Commented))
+	fmt.Fprintf(w, "Overdue:\t%d\n", len(r.OverdueReview))
+	fmt.Fprintf(w, "Awaiting author:\t%d\n", len(r.AwaitingAuthor))
+	fmt.Fprintf(w, "Unassigned:\t%d\n", len(r.Unassigned))
+}
+
+// PrintDetailedReport prints the summary of PrintReport followed by the number, title, author and
+// URL of each PR in the Approved, Changes requested, Blocked on author, Commented, Overdue, Awaiting author
+// and Unassigned buckets, oldest first.
+func PrintDetailedReport(out io.Writer, r Report) {
+	PrintReport(out, r)
+
+	w := &tabwriter.Writer{}
+	w.Init(out, 10, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	for _, bucket := range []struct {
+		Name string
+		PRs  []*PullRequest
+	}{
+		{"Approved", r.Approved},
+		{"Approved by required approver", r.ApprovedByRequired},
+		{"Approved, CI failing", r.ApprovedCIFailing},
+		{"Approved, stale", r.ApprovedStale},
+		{"Changes requested", r.ChangesRequested},
+		{"Blocked on author", r.BlockedOnAuthor},
+		{"Commented", r.Commented},
+		{"Overdue", r.OverdueReview},
+		{"Awaiting author", r.AwaitingAuthor},
+		{"Unassigned", r.Unassigned},
+	} {
+		if len(bucket.PRs) == 0 {
+			continue
+		}
+
+		prs := make([]*PullRequest, len(bucket.PRs))
+		copy(prs, bucket.PRs)
+		sort.Slice(prs, func(i, j int) bool { return prs[i].CreatedAt.Before(prs[j].CreatedAt.Time) })
+
+		fmt.Fprintf(w, "\n%s:\n", bucket.Name)
+		for _, pr := range prs {
+			fmt.Fprintf(w, "  #%d\t%s\t%s\t%s\t%s\n", pr.Number, pr.Title, pr.Author.Login, r.GeneratedAt.Sub(pr.CreatedAt.Time).Round(time.Minute), pr.Link())
+		}
+	}
+}
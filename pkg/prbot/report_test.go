@@ -0,0 +1,402 @@
+package prbot
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shurcooL/githubv4"
+)
+
+var testNow = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func testOptions() Options {
+	return Options{
+		OverdueAfter:        24 * time.Hour,
+		AwaitingAuthorAfter: 48 * time.Hour,
+		ApprovedStaleAfter:  72 * time.Hour,
+		Now:                 func() time.Time { return testNow },
+	}
+}
+
+// testPR returns an open PR by alice created age before testNow
+func testPR(age time.Duration, reviews ...PullRequestReview) PullRequest {
+	var pr PullRequest
+	pr.Number = 1
+	pr.Author.Login = "alice"
+	pr.State = githubv4.PullRequestStateOpen
+	pr.CreatedAt = githubv4.GitTimestamp{Time: testNow.Add(-age)}
+	pr.Reviews.Nodes = reviews
+	pr.Reviews.TotalCount = len(reviews)
+	return pr
+}
+
+// testReview returns a review by login in state submitted age before testNow
+func testReview(login string, state githubv4.PullRequestReviewState, age time.Duration) PullRequestReview {
+	var review PullRequestReview
+	review.Author.Login = login
+	review.State = state
+	review.SubmittedAt = githubv4.GitTimestamp{Time: testNow.Add(-age)}
+	return review
+}
+
+// testBuckets returns the states the PRs of ReportWIP(prs, opts) are in, keyed by PR number
+func testBuckets(prs []PullRequest, opts Options) map[int][]string {
+	res := ReportWIP(prs, opts).BucketsByNumber()
+	for n, states := range res {
+		if states == nil {
+			res[n] = []string{}
+		}
+	}
+	return res
+}
+
+func TestReportWIP(t *testing.T) {
+	draft := testPR(100 * time.Hour)
+	draft.IsDraft = true
+
+	tests := []struct {
+		Name    string
+		PR      PullRequest
+		Buckets []string
+	}{
+		{
+			Name:    "draft",
+			PR:      draft,
+			Buckets: []string{"draft"},
+		},
+		{
+			Name:    "approved",
+			PR:      testPR(100*time.Hour, testReview("bob", githubv4.PullRequestReviewStateApproved, time.Hour)),
+			Buckets: []string{"approved", "approved_by_required"},
+		},
+		{
+			Name:    "commented",
+			PR:      testPR(30*time.Hour, testReview("bob", githubv4.PullRequestReviewStateCommented, 2*time.Hour)),
+			Buckets: []string{"commented"},
+		},
+		{
+			Name:    "overdue",
+			PR:      testPR(25 * time.Hour),
+			Buckets: []string{"overdue", "unassigned"},
+		},
+		{
+			Name:    "exactly at the overdue threshold",
+			PR:      testPR(24 * time.Hour),
+			Buckets: []string{"unassigned"},
+		},
+		{
+			Name:    "commented exactly at the overdue threshold",
+			PR:      testPR(100*time.Hour, testReview("bob", githubv4.PullRequestReviewStateCommented, 24*time.Hour)),
+			Buckets: []string{"commented"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			act := testBuckets([]PullRequest{test.PR}, testOptions())
+			exp := map[int][]string{1: test.Buckets}
+			if !reflect.DeepEqual(act, exp) {
+				t.Errorf("unexpected buckets: expected %v, got %v", exp, act)
+			}
+		})
+	}
+}
+
+func TestReportWIPGeneratedAt(t *testing.T) {
+	r := ReportWIP(nil, testOptions())
+	if !r.GeneratedAt.Equal(testNow) {
+		t.Errorf("unexpected GeneratedAt: expected %v, got %v", testNow, r.GeneratedAt)
+	}
+}
+
+func TestPrintDetailedReportUsesGeneratedAt(t *testing.T) {
+	var out bytes.Buffer
+	PrintDetailedReport(&out, ReportWIP([]PullRequest{testPR(25 * time.Hour)}, testOptions()))
+	if !strings.Contains(out.String(), "25h0m0s") {
+		t.Errorf("expected the age relative to Options.Now, got:\n%s", out.String())
+	}
+}
+
+func TestOverdueThreshold(t *testing.T) {
+	tests := []struct {
+		OverdueAfter time.Duration
+		Overdue      bool
+	}{
+		{24 * time.Hour, true},
+		{48 * time.Hour, false},
+	}
+	for _, test := range tests {
+		t.Run(test.OverdueAfter.String(), func(t *testing.T) {
+			opts := testOptions()
+			opts.OverdueAfter = test.OverdueAfter
+			r := ReportWIP([]PullRequest{testPR(25 * time.Hour)}, opts)
+			if act := len(r.OverdueReview) == 1; act != test.Overdue {
+				t.Errorf("unexpected overdue: expected %v, got %v", test.Overdue, act)
+			}
+		})
+	}
+}
+
+func TestCommentedOnce(t *testing.T) {
+	pr := testPR(10*time.Hour,
+		testReview("bob", githubv4.PullRequestReviewStateCommented, 3*time.Hour),
+		testReview("carol", githubv4.PullRequestReviewStateCommented, 2*time.Hour),
+		testReview("bob", githubv4.PullRequestReviewStateCommented, time.Hour),
+	)
+	r := ReportWIP([]PullRequest{pr}, testOptions())
+	if len(r.Commented) != 1 {
+		t.Errorf("expected 1 commented PR, got %d", len(r.Commented))
+	}
+}
+
+func TestStaleCommentIsOverdue(t *testing.T) {
+	pr := testPR(100*time.Hour, testReview("bob", githubv4.PullRequestReviewStateCommented, 72*time.Hour))
+	r := ReportWIP([]PullRequest{pr}, testOptions())
+	if len(r.Commented) != 1 {
+		t.Errorf("expected the PR to be commented, got %d commented PRs", len(r.Commented))
+	}
+	if len(r.OverdueReview) != 1 {
+		t.Errorf("expected the PR to be overdue, got %d overdue PRs", len(r.OverdueReview))
+	}
+}
+
+func TestIgnoreAuthors(t *testing.T) {
+	tests := []struct {
+		Author  string
+		Ignored bool
+	}{
+		{"dependabot", true},
+		{"release-bot", true},
+		{"alice", false},
+		{"dependabot-fan", false},
+		{"bot", false},
+	}
+	for _, test := range tests {
+		t.Run(test.Author, func(t *testing.T) {
+			pr := testPR(time.Hour)
+			pr.Author.Login = test.Author
+			opts := testOptions()
+			opts.IgnoreAuthors = []string{"dependabot", "*-bot"}
+			r := ReportWIP([]PullRequest{pr}, opts)
+			if act := len(r.Open) == 0; act != test.Ignored {
+				t.Errorf("unexpected ignored: expected %v, got %v", test.Ignored, act)
+			}
+		})
+	}
+}
+
+func TestApprovedStaleBoundary(t *testing.T) {
+	tests := []struct {
+		Name        string
+		ApprovalAge time.Duration
+		Stale       bool
+	}{
+		{"just before", 72*time.Hour - time.Second, false},
+		{"exactly at", 72 * time.Hour, false},
+		{"just after", 72*time.Hour + time.Second, true},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			pr := testPR(100*time.Hour, testReview("bob", githubv4.PullRequestReviewStateApproved, test.ApprovalAge))
+			r := ReportWIP([]PullRequest{pr}, testOptions())
+			if act := len(r.ApprovedStale) == 1; act != test.Stale {
+				t.Errorf("unexpected stale: expected %v, got %v", test.Stale, act)
+			}
+		})
+	}
+}
+
+func TestIsOverdueWithoutComments(t *testing.T) {
+	// without comments, the wait starts at creation rather than at the zero time
+	opts := testOptions()
+	pr := testPR(time.Hour)
+	if opts.IsOverdue(&pr, opts.OverdueAfter) {
+		t.Errorf("PR without comments created an hour ago is overdue")
+	}
+	pr = testPR(25 * time.Hour)
+	if !opts.IsOverdue(&pr, opts.OverdueAfter) {
+		t.Errorf("PR without comments created 25 hours ago is not overdue")
+	}
+}
+
+func TestLatestReviewDecides(t *testing.T) {
+	tests := []struct {
+		Name             string
+		Reviews          []PullRequestReview
+		Approved         bool
+		ChangesRequested bool
+	}{
+		{
+			Name: "approve then request changes",
+			Reviews: []PullRequestReview{
+				testReview("bob", githubv4.PullRequestReviewStateApproved, 5*time.Hour),
+				testReview("bob", githubv4.PullRequestReviewStateChangesRequested, time.Hour),
+			},
+			ChangesRequested: true,
+		},
+		{
+			Name: "request changes then approve",
+			Reviews: []PullRequestReview{
+				testReview("bob", githubv4.PullRequestReviewStateChangesRequested, 5*time.Hour),
+				testReview("bob", githubv4.PullRequestReviewStateApproved, time.Hour),
+			},
+			Approved: true,
+		},
+		{
+			Name: "another reviewer requests changes",
+			Reviews: []PullRequestReview{
+				testReview("bob", githubv4.PullRequestReviewStateApproved, 5*time.Hour),
+				testReview("carol", githubv4.PullRequestReviewStateChangesRequested, time.Hour),
+			},
+			ChangesRequested: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := ReportWIP([]PullRequest{testPR(10*time.Hour, test.Reviews...)}, testOptions())
+			if act := len(r.Approved) == 1; act != test.Approved {
+				t.Errorf("unexpected approved: expected %v, got %v", test.Approved, act)
+			}
+			if act := len(r.ChangesRequested) == 1; act != test.ChangesRequested {
+				t.Errorf("unexpected changes requested: expected %v, got %v", test.ChangesRequested, act)
+			}
+		})
+	}
+}
+
+func TestMinAgeBoundary(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Age     time.Duration
+		Buckets []string
+	}{
+		{"just before", 2*time.Hour - time.Second, []string{}},
+		{"exactly at", 2 * time.Hour, []string{"overdue", "unassigned"}},
+		{"just after", 2*time.Hour + time.Second, []string{"overdue", "unassigned"}},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			opts := testOptions()
+			opts.OverdueAfter = time.Hour
+			opts.MinAge = 2 * time.Hour
+			act := testBuckets([]PullRequest{testPR(test.Age)}, opts)
+			exp := map[int][]string{1: test.Buckets}
+			if !reflect.DeepEqual(act, exp) {
+				t.Errorf("unexpected buckets: expected %v, got %v", exp, act)
+			}
+		})
+	}
+}
+
+func TestOverdueUsesCommentedStates(t *testing.T) {
+	// the dismissed review only counts as a comment if configured
+	pr := testPR(100*time.Hour, testReview("bob", githubv4.PullRequestReviewStateDismissed, time.Hour))
+	tests := []struct {
+		Name    string
+		States  []githubv4.PullRequestReviewState
+		Overdue bool
+	}{
+		{"default", nil, true},
+		{"dismissed counts", []githubv4.PullRequestReviewState{githubv4.PullRequestReviewStateCommented, githubv4.PullRequestReviewStateDismissed}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			opts := testOptions()
+			opts.CommentedStates = test.States
+			r := ReportWIP([]PullRequest{pr}, opts)
+			if act := len(r.OverdueReview) == 1; act != test.Overdue {
+				t.Errorf("unexpected overdue: expected %v, got %v", test.Overdue, act)
+			}
+			if act := opts.IsOverdue(&pr, opts.OverdueAfter); act != test.Overdue {
+				t.Errorf("IsOverdue disagrees with the report: expected %v, got %v", test.Overdue, act)
+			}
+		})
+	}
+}
+
+func TestAwaitingAuthorIgnoresAuthorReplies(t *testing.T) {
+	tests := []struct {
+		Name           string
+		Reviews        []PullRequestReview
+		AwaitingAuthor bool
+	}{
+		{
+			Name:           "reviewer comment",
+			Reviews:        []PullRequestReview{testReview("bob", githubv4.PullRequestReviewStateCommented, 50*time.Hour)},
+			AwaitingAuthor: true,
+		},
+		{
+			Name: "author replied",
+			Reviews: []PullRequestReview{
+				testReview("bob", githubv4.PullRequestReviewStateCommented, 100*time.Hour),
+				testReview("alice", githubv4.PullRequestReviewStateCommented, 50*time.Hour),
+			},
+		},
+		{
+			Name: "reviewer answered the reply",
+			Reviews: []PullRequestReview{
+				testReview("alice", githubv4.PullRequestReviewStateCommented, 100*time.Hour),
+				testReview("bob", githubv4.PullRequestReviewStateCommented, 50*time.Hour),
+			},
+			AwaitingAuthor: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			r := ReportWIP([]PullRequest{testPR(200*time.Hour, test.Reviews...)}, testOptions())
+			if act := len(r.AwaitingAuthor) == 1; act != test.AwaitingAuthor {
+				t.Errorf("unexpected awaiting author: expected %v, got %v", test.AwaitingAuthor, act)
+			}
+		})
+	}
+}
+
+func TestBlockedOnAuthor(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Reviews []PullRequestReview
+		Blocked bool
+	}{
+		{
+			Name:    "no commit since the change request",
+			Reviews: []PullRequestReview{testReview("bob", githubv4.PullRequestReviewStateChangesRequested, 5*time.Hour)},
+			Blocked: true,
+		},
+		{
+			Name:    "commit since the change request",
+			Reviews: []PullRequestReview{testReview("bob", githubv4.PullRequestReviewStateChangesRequested, 10*time.Hour)},
+		},
+		{
+			Name: "approval after a later change request",
+			Reviews: []PullRequestReview{
+				testReview("bob", githubv4.PullRequestReviewStateChangesRequested, 10*time.Hour),
+				testReview("carol", githubv4.PullRequestReviewStateChangesRequested, 5*time.Hour),
+				testReview("carol", githubv4.PullRequestReviewStateApproved, 2*time.Hour),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			pr := testPR(20*time.Hour, test.Reviews...)
+			pr.Commits.Nodes = make([]struct {
+				Commit struct {
+					CommittedDate     githubv4.GitTimestamp
+					StatusCheckRollup *struct {
+						State githubv4.StatusState
+					}
+				}
+			}, 1)
+			pr.Commits.Nodes[0].Commit.CommittedDate = githubv4.GitTimestamp{Time: testNow.Add(-8 * time.Hour)}
+
+			r := ReportWIP([]PullRequest{pr}, testOptions())
+			if len(r.ChangesRequested) != 1 {
+				t.Fatalf("expected changes to be requested")
+			}
+			if act := len(r.BlockedOnAuthor) == 1; act != test.Blocked {
+				t.Errorf("unexpected blocked on author: expected %v, got %v", test.Blocked, act)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package prbot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Repository identifies a GitHub repository
+type Repository struct {
+	Owner string
+	Name  string
+}
+
+func (r Repository) String() string {
+	return r.Owner + "/" + r.Name
+}
+
+// ParseRepo parses an owner/name pair
+func ParseRepo(s string) (Repository, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Repository{}, fmt.Errorf("invalid repository %q: expected owner/name", s)
+	}
+	return Repository{Owner: parts[0], Name: parts[1]}, nil
+}
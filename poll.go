@@ -0,0 +1,618 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/shurcooL/githubv4"
+	log "github.com/sirupsen/logrus"
+)
+
+// poller periodically downloads the pull requests of all repositories and updates the metrics and reports
+type poller struct {
+	Client     *githubv4.Client
+	RateLimits *rateLimitTransport
+	Repos      []prbot.Repository
+	// Search is a GitHub search query. If set, the PRs it finds are reported instead of those of Repos.
+	Search string
+	// MaxPages bounds the number of result pages downloaded per repository or search
+	MaxPages int
+	// States are the states of the pull requests downloaded per repository. Only the open ones are
+	// reported, merged ones feed the merge latency metrics. Has no effect in search mode.
+	States []githubv4.PullRequestState
+	// HistoryWindow bounds how long ago closed and merged pull requests may have been updated to be
+	// downloaded
+	HistoryWindow time.Duration
+	// Org is a GitHub organization. If set, the PRs of all its non-archived repositories are reported
+	// instead of those of Repos.
+	Org string
+	// ExcludeRepos are never fetched in org mode
+	ExcludeRepos []prbot.Repository
+	// Concurrency is the number of repositories fetched at the same time
+	Concurrency int
+	Options     prbot.Options
+	Metrics     metricsOptions
+	// RequiredApprovers are logins and org/team slugs. Before each poll, the teams are resolved to
+	// their members, and the result overrides Options.RequiredApprovers.
+	RequiredApprovers []string
+	// Interval is the time between scheduled polls. It also bounds how long a poll waits for a
+	// rate limit reset.
+	Interval time.Duration
+	// Timeout bounds how long fetching the pull requests of a single repository may take
+	Timeout time.Duration
+	Retry   backoff
+	Reports *reportStore
+	// PullRequests receives the unfiltered pull requests of each poll
+	PullRequests *pullRequestStore
+	Health       *healthTracker
+	// Slack is notified about newly overdue PRs. Nil disables notifications.
+	Slack *slackNotifier
+	// PagerDuty is notified about critically overdue PRs. Nil disables notifications.
+	PagerDuty *pagerDutyNotifier
+	// Snapshots receives the reports of each poll. Nil disables snapshots.
+	Snapshots *snapshotWriter
+	// Digest receives the reports of the first poll of each day. Nil disables the digest.
+	Digest *digestNotifier
+	// LabelAllowlist restricts the per-label metrics to these labels. If empty, all labels are counted.
+	LabelAllowlist []string
+	// OverdueSmoothing is the weight of the latest poll in the moving average of overdue PRs, between
+	// 0 (exclusive) and 1. 1 disables smoothing.
+	OverdueSmoothing float64
+	// SkipUnchanged reuses the pull requests of the previous poll if a cheap probe shows that none
+	// of them was updated. Has no effect in search mode. CI status and mergeability changes don't
+	// update a PR, hence they go unnoticed until a PR changes or SkipUnchangedMaxAge passes.
+	SkipUnchanged bool
+	// SkipUnchangedMaxAge is the age after which reused pull requests are downloaded anyway
+	SkipUnchangedMaxAge time.Duration
+
+	// mu prevents scheduled and out-of-band polls from overlapping
+	mu sync.Mutex
+	// Events logs bucket transitions
+	Events *bucketLog
+
+	// overdueAverage holds the moving average of overdue PRs of each repository, guarded by mu
+	overdueAverage map[string]float64
+	// polled holds the repositories polled by the previous poll, guarded by mu
+	polled map[string]prbot.Repository
+	// denied holds the repositories whose permission errors were logged already, guarded by mu
+	denied map[string]bool
+	// teamMembers holds the most recently resolved members of each team in RequiredApprovers
+	teamMembers map[string][]string
+	// overdue tracks which PRs became overdue
+	overdue transitionTracker
+	// cache holds the most recent pull requests of each repository if SkipUnchanged is set
+	cache   map[string]cachedPullRequests
+	cacheMu sync.Mutex
+}
+
+type cachedPullRequests struct {
+	Probe     prbot.PullRequestsProbe
+	PRs       repoPullRequests
+	FetchedAt time.Time
+}
+
+// repoPullRequests are the pull requests of a repository
+type repoPullRequests struct {
+	Repo prbot.Repository
+	PRs  []prbot.PullRequest
+	// Truncated is true if not all pull requests were downloaded because of poller.MaxPages
+	Truncated bool
+}
+
+// includesMerged returns true if merged pull requests are downloaded
+func (p *poller) includesMerged() bool {
+	if p.Search != "" {
+		return false
+	}
+	for _, s := range p.States {
+		if s == githubv4.PullRequestStateMerged {
+			return true
+		}
+	}
+	return false
+}
+
+// Run polls every Interval until ctx is cancelled
+func (p *poller) Run(ctx context.Context) {
+	t := time.NewTicker(p.Interval)
+	defer t.Stop()
+
+	for {
+		p.Poll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Poll downloads the pull requests of all repositories once. It returns true if all repositories
+// were fetched successfully, the poll counts towards health if at least one was.
+func (p *poller) Poll(ctx context.Context) (success bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ctx = withPollID(ctx)
+	ctx = context.WithValue(ctx, pollLockKey{}, &pollLock{mu: &p.mu})
+	log.WithContext(ctx).Debug("polling GitHub")
+	res, repos, failed := p.fetchAll(ctx)
+	if ctx.Err() != nil {
+		return false
+	}
+	if repos != nil {
+		p.forgetRepositories(ctx, repos)
+	}
+
+	base := p.reportOptions(ctx)
+	reports := make(map[string]prbot.Report, len(res))
+	for _, r := range res {
+		opts := base.ForRepo(r.Repo)
+		report := prbot.ReportWIP(r.PRs, opts)
+		updateMetrics(r.Repo, p.Metrics, report)
+		updateLabelMetrics(r.Repo, report, p.LabelAllowlist)
+		if p.includesMerged() {
+			updateMergedMetrics(r.Repo, r.PRs, time.Now().Add(-p.HistoryWindow))
+		}
+		p.Reports.Set(r.Repo, report)
+		reports[r.Repo.String()] = report
+		p.PullRequests.Set(r.Repo, r.PRs)
+		if p.Events != nil {
+			p.Events.Log(r.Repo, report)
+		}
+		overdueAverage.WithLabelValues(r.Repo.String()).Set(p.averageOverdue(r.Repo, len(report.OverdueReview)))
+		transitions := overdueTransitionsTotal.WithLabelValues(r.Repo.String())
+		if entered, seen := p.overdue.Entered(r.Repo, report.OverdueReview); seen {
+			transitions.Add(float64(len(entered)))
+		}
+
+		if p.Slack != nil {
+			err := p.Slack.NotifyOverdue(ctx, r.Repo, report)
+			if err != nil {
+				log.WithContext(ctx).WithError(err).WithField("repo", r.Repo.String()).Warn("cannot notify Slack")
+			}
+		}
+		if p.PagerDuty != nil {
+			err := p.PagerDuty.Notify(ctx, r.Repo, opts, report)
+			if err != nil {
+				log.WithContext(ctx).WithError(err).WithField("repo", r.Repo.String()).Warn("cannot notify PagerDuty")
+			}
+		}
+	}
+	if p.Snapshots != nil && len(reports) > 0 {
+		// a full disk must not stop polling
+		err := p.Snapshots.Write(time.Now(), reports)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Warn("cannot write snapshot")
+		}
+	}
+	// a partial digest would be misleading for a whole day
+	if p.Digest != nil && failed == 0 && len(reports) > 0 {
+		err := p.Digest.Post(ctx, time.Now(), reports)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Warn("cannot post digest")
+		}
+	}
+	// failures of single repositories are reported by poll_failing and do not make us unhealthy
+	if failed == 0 || len(res) > 0 {
+		p.Health.MarkSuccess(time.Now())
+	}
+	return failed == 0
+}
+
+// serveRefresh polls immediately and responds with the fresh report
+func (p *poller) serveRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !p.Poll(r.Context()) {
+		http.Error(w, "cannot download pull requests of all repositories, see logs for details", http.StatusBadGateway)
+		return
+	}
+	p.Reports.ServeHTTP(w, r)
+}
+
+// fetchAll downloads the pull requests of all repositories, of the organization's repositories, or
+// those found by the search query.
+// Failures are logged and counted, and the number of failed fetches is returned. repos are the
+// repositories which are polled now, including failed ones, or nil if they are unknown because
+// searching or listing the organization's repositories failed.
+func (p *poller) fetchAll(ctx context.Context) (res []repoPullRequests, repos []prbot.Repository, failed int) {
+	if p.Search != "" {
+		logger := log.WithContext(ctx).WithField("repo", "search").WithField("search", p.Search)
+		prs, truncated, err := p.fetchWithRetry(ctx, logger, func(ctx context.Context) ([]prbot.PullRequest, bool, error) {
+			return prbot.SearchPullRequests(ctx, p.Client, p.Search, prbot.FetchOptions{
+				MaxPages:    p.MaxPages,
+				OnRateLimit: updateRateLimitMetrics,
+			})
+		})
+		if ctx.Err() != nil {
+			return nil, nil, 0
+		}
+		if err != nil {
+			p.fetchFailed(logger, "search", err, "cannot search pull requests")
+			return nil, nil, 1
+		}
+		p.fetchSucceeded("search")
+		lastPollTimestamp.WithLabelValues("search").SetToCurrentTime()
+		res = groupByRepository(ctx, prs)
+		for i := range res {
+			res[i].Truncated = truncated
+			repos = append(repos, res[i].Repo)
+		}
+		return res, repos, 0
+	}
+
+	repos = p.Repos
+	if p.Org != "" {
+		logger := log.WithContext(ctx).WithField("org", p.Org)
+		err := retry(ctx, logger, p.Retry, func() error {
+			listCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+			defer cancel()
+
+			var err error
+			repos, err = prbot.ListOrgRepositories(listCtx, p.Client, p.Org)
+			return err
+		})
+		if ctx.Err() != nil {
+			return nil, nil, 0
+		}
+		if err != nil {
+			p.fetchFailed(logger, p.Org+"/*", err, "cannot list organization repositories")
+			return nil, nil, 1
+		}
+		p.fetchSucceeded(p.Org + "/*")
+		repos = excludeRepositories(repos, p.ExcludeRepos)
+	}
+
+	type result struct {
+		PRs repoPullRequests
+		Err error
+	}
+	var (
+		results = make([]result, len(repos))
+		sem     = make(chan struct{}, p.concurrency())
+		wg      sync.WaitGroup
+	)
+	for i, repo := range repos {
+		i, repo := i, repo
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			prs, err := p.fetchRepository(ctx, repo)
+			results[i] = result{PRs: prs, Err: err}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		// we're shutting down - these are not failed fetches
+		return nil, nil, 0
+	}
+	for i, r := range results {
+		if r.Err != nil {
+			p.fetchFailed(log.WithContext(ctx).WithField("repo", repos[i].String()), repos[i].String(), r.Err, "cannot download pull requests")
+			failed++
+			continue
+		}
+		p.fetchSucceeded(repos[i].String())
+		lastPollTimestamp.WithLabelValues(repos[i].String()).SetToCurrentTime()
+		res = append(res, r.PRs)
+	}
+	return res, repos, failed
+}
+
+// forgetRepositories deletes the metrics and reports of the repositories polled before which are not
+// among repos, e.g. because they no longer match the search query or were archived
+func (p *poller) forgetRepositories(ctx context.Context, repos []prbot.Repository) {
+	current := make(map[string]prbot.Repository, len(repos))
+	for _, repo := range repos {
+		current[repo.String()] = repo
+	}
+	for name, repo := range p.polled {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		log.WithContext(ctx).WithField("repo", name).Info("repository is no longer polled, deleting its metrics and reports")
+		deleteMetrics(repo)
+		p.Reports.Delete(repo)
+		p.PullRequests.Delete(repo)
+		delete(p.overdueAverage, name)
+		p.cacheMu.Lock()
+		delete(p.cache, name)
+		p.cacheMu.Unlock()
+	}
+	p.polled = current
+}
+
+// averageOverdue adds the number of overdue PRs of repo to its exponentially weighted moving
+// average and returns the new average. The first poll starts the average at count.
+func (p *poller) averageOverdue(repo prbot.Repository, count int) float64 {
+	if p.overdueAverage == nil {
+		p.overdueAverage = make(map[string]float64)
+	}
+
+	avg, ok := p.overdueAverage[repo.String()]
+	if !ok || p.OverdueSmoothing <= 0 {
+		avg = float64(count)
+	} else {
+		avg = p.OverdueSmoothing*float64(count) + (1-p.OverdueSmoothing)*avg
+	}
+	p.overdueAverage[repo.String()] = avg
+	return avg
+}
+
+// reportOptions returns Options with the members of the RequiredApprovers teams resolved. If a team
+// cannot be resolved, its members of the previous poll are used.
+func (p *poller) reportOptions(ctx context.Context) prbot.Options {
+	opts := p.Options
+	opts.Logger = log.WithContext(ctx)
+	if len(p.RequiredApprovers) == 0 {
+		return opts
+	}
+
+	// not nil, so that no approval counts if none of the teams can be resolved
+	approvers := make([]string, 0, len(p.RequiredApprovers))
+	for _, approver := range p.RequiredApprovers {
+		segs := strings.SplitN(approver, "/", 2)
+		if len(segs) != 2 {
+			approvers = append(approvers, approver)
+			continue
+		}
+
+		logger := log.WithContext(ctx).WithField("team", approver)
+		var members []string
+		err := retry(ctx, logger, p.Retry, func() error {
+			listCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+			defer cancel()
+
+			var err error
+			members, err = prbot.ListTeamMembers(listCtx, p.Client, segs[0], segs[1])
+			return err
+		})
+		if err != nil {
+			logger.WithError(err).Warn("cannot resolve required approvers, using those of the previous poll")
+			members = p.teamMembers[approver]
+		} else {
+			if p.teamMembers == nil {
+				p.teamMembers = make(map[string][]string)
+			}
+			p.teamMembers[approver] = members
+		}
+		approvers = append(approvers, members...)
+	}
+	opts.RequiredApprovers = approvers
+	return opts
+}
+
+// fetchFailed logs and counts a failed fetch of target. Permission errors won't go away by
+// themselves, hence they are logged with a hint only once until target was fetched successfully.
+func (p *poller) fetchFailed(logger *log.Entry, target string, err error, msg string) {
+	pollFailing.WithLabelValues(target).Set(1)
+	if !isPermissionError(err) {
+		logger.WithError(err).Error(msg)
+		pollErrorsTotal.WithLabelValues(target, "transient").Inc()
+		return
+	}
+
+	pollErrorsTotal.WithLabelValues(target, "permission").Inc()
+	if p.denied[target] {
+		logger.WithError(err).Debug(msg)
+		return
+	}
+	if p.denied == nil {
+		p.denied = make(map[string]bool)
+	}
+	p.denied[target] = true
+	logger.WithError(err).Errorf("%s: the GitHub token is invalid or cannot access %s. Make sure it exists and the token has the repo scope, or the GitHub App is installed on it. Further failures are logged at debug level.", msg, target)
+}
+
+// fetchSucceeded re-enables logging permission errors of target
+func (p *poller) fetchSucceeded(target string) {
+	pollFailing.WithLabelValues(target).Set(0)
+	delete(p.denied, target)
+}
+
+func (p *poller) concurrency() int {
+	if p.Concurrency <= 0 {
+		return 1
+	}
+	return p.Concurrency
+}
+
+type pollLockKey struct{}
+
+// pollLock is a poll's hold on poller.mu. The fetches of a poll run concurrently, hence the first
+// of them to wait for a rate limit reset releases mu and the last one done waiting reacquires it.
+type pollLock struct {
+	mu *sync.Mutex
+
+	waitMu  sync.Mutex
+	waiting int
+}
+
+func (l *pollLock) release() {
+	l.waitMu.Lock()
+	defer l.waitMu.Unlock()
+
+	l.waiting++
+	if l.waiting == 1 {
+		l.mu.Unlock()
+	}
+}
+
+func (l *pollLock) reacquire() {
+	l.waitMu.Lock()
+	defer l.waitMu.Unlock()
+
+	l.waiting--
+	if l.waiting == 0 {
+		l.mu.Lock()
+	}
+}
+
+// fetchRepository downloads the pull requests of repo, or reuses those of the previous poll if
+// SkipUnchanged is set and they did not change.
+func (p *poller) fetchRepository(ctx context.Context, repo prbot.Repository) (repoPullRequests, error) {
+	logger := log.WithContext(ctx).WithField("repo", repo.String())
+
+	var probe prbot.PullRequestsProbe
+	if p.SkipUnchanged {
+		var (
+			cached cachedPullRequests
+			ok     bool
+		)
+		probe, cached, ok = p.probeCache(ctx, logger, repo)
+		if ok {
+			logger.Debug("pull requests unchanged since last poll, reusing them")
+			return cached.PRs, nil
+		}
+	}
+
+	prs, truncated, err := p.fetchWithRetry(ctx, logger, func(ctx context.Context) ([]prbot.PullRequest, bool, error) {
+		return prbot.GetPullRequests(ctx, p.Client, repo.Owner, repo.Name, prbot.FetchOptions{
+			MaxPages:    p.MaxPages,
+			States:      p.States,
+			Since:       time.Now().Add(-p.HistoryWindow),
+			OnRateLimit: updateRateLimitMetrics,
+		})
+	})
+	if err != nil {
+		return repoPullRequests{}, err
+	}
+
+	r := repoPullRequests{Repo: repo, PRs: prs, Truncated: truncated}
+	if p.SkipUnchanged && !probe.UpdatedAt.IsZero() {
+		p.cacheMu.Lock()
+		if p.cache == nil {
+			p.cache = make(map[string]cachedPullRequests)
+		}
+		p.cache[repo.String()] = cachedPullRequests{Probe: probe, PRs: r, FetchedAt: time.Now()}
+		p.cacheMu.Unlock()
+	}
+	return r, nil
+}
+
+// probeCache probes the pull requests of repo and returns the cached ones if they did not change
+// and are younger than SkipUnchangedMaxAge. If probing fails, the error is logged and a full fetch should happen.
+func (p *poller) probeCache(ctx context.Context, logger *log.Entry, repo prbot.Repository) (probe prbot.PullRequestsProbe, cached cachedPullRequests, unchanged bool) {
+	probeCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+	defer cancel()
+
+	probe, err := prbot.ProbePullRequests(probeCtx, p.Client, repo.Owner, repo.Name)
+	if err != nil {
+		logger.WithError(err).Warn("cannot probe pull requests, downloading all of them")
+		return prbot.PullRequestsProbe{}, cachedPullRequests{}, false
+	}
+
+	p.cacheMu.Lock()
+	cached, ok := p.cache[repo.String()]
+	p.cacheMu.Unlock()
+	unchanged = ok && cached.Probe.TotalCount == probe.TotalCount && cached.Probe.UpdatedAt.Equal(probe.UpdatedAt) &&
+		time.Since(cached.FetchedAt) < p.SkipUnchangedMaxAge
+	return probe, cached, unchanged
+}
+
+// excludeRepositories returns repos without those in exclude
+func excludeRepositories(repos, exclude []prbot.Repository) []prbot.Repository {
+	if len(exclude) == 0 {
+		return repos
+	}
+
+	res := make([]prbot.Repository, 0, len(repos))
+	for _, repo := range repos {
+		var excluded bool
+		for _, ex := range exclude {
+			if strings.EqualFold(repo.String(), ex.String()) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			res = append(res, repo)
+		}
+	}
+	return res
+}
+
+// groupByRepository splits prs by the repository they belong to, keeping the order of first appearance
+func groupByRepository(ctx context.Context, prs []prbot.PullRequest) []repoPullRequests {
+	var (
+		res []repoPullRequests
+		idx = make(map[string]int)
+	)
+	for _, pr := range prs {
+		repo, err := prbot.ParseRepo(pr.Repository.NameWithOwner)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).WithField("title", string(pr.Title)).Warn("cannot determine repository of PR")
+			continue
+		}
+
+		i, ok := idx[repo.String()]
+		if !ok {
+			i = len(res)
+			idx[repo.String()] = i
+			res = append(res, repoPullRequests{Repo: repo})
+		}
+		res[i].PRs = append(res[i].PRs, pr)
+	}
+	return res
+}
+
+// fetchWithRetry calls fetch with a timeout, retrying transient failures with exponential backoff.
+// If GitHub rate limits us, it waits for the rate limit to reset and tries once more.
+func (p *poller) fetchWithRetry(ctx context.Context, logger *log.Entry, fetch func(context.Context) ([]prbot.PullRequest, bool, error)) (prs []prbot.PullRequest, truncated bool, err error) {
+	attempt := func() error {
+		return retry(ctx, logger, p.Retry, func() error {
+			fetchCtx, cancel := context.WithTimeout(ctx, p.Timeout)
+			defer cancel()
+
+			prs, truncated, err = fetch(fetchCtx)
+			return err
+		})
+	}
+
+	err = attempt()
+	if isRateLimitError(err) && p.waitForRateLimitReset(ctx, logger) {
+		err = attempt()
+	}
+	return prs, truncated, err
+}
+
+// waitForRateLimitReset blocks until GitHub allows retrying, as told by Retry-After or else the
+// rate limit reset, but no longer than Interval. The poll's hold on mu is released meanwhile, so
+// that out-of-band polls are not blocked. It returns false if the retry time is unknown or ctx was
+// cancelled while waiting.
+func (p *poller) waitForRateLimitReset(ctx context.Context, logger *log.Entry) bool {
+	retryAt := p.RateLimits.RetryAt()
+	if retryAt.IsZero() {
+		return false
+	}
+
+	wait := time.Until(retryAt)
+	if p.Interval > 0 && wait > p.Interval {
+		wait = p.Interval
+	}
+	logger.WithField("retryAt", retryAt.Format(time.RFC3339)).Warnf("rate limited by GitHub, waiting %s before retrying", wait.Round(time.Second))
+	if l, ok := ctx.Value(pollLockKey{}).(*pollLock); ok {
+		l.release()
+		defer l.reacquire()
+	}
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/shurcooL/githubv4"
+)
+
+func TestFetchAllBoundsConcurrency(t *testing.T) {
+	const (
+		repos       = 10
+		concurrency = 3
+	)
+
+	var (
+		mu                    sync.Mutex
+		inFlight, maxInFlight int
+	)
+	transport := roundTripFunc(func(req *http.Request) *http.Response {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return testResponse(`{"data": {"repository": {"pullRequests": {"nodes": [], "pageInfo": {"hasNextPage": false}}}}}`, nil)
+	})
+
+	p := &poller{
+		Client:      githubv4.NewEnterpriseClient("https://github.test/api/graphql", &http.Client{Transport: transport}),
+		Concurrency: concurrency,
+		Retry:       backoff{MaxAttempts: 1},
+		Timeout:     10 * time.Second,
+	}
+	for i := 0; i < repos; i++ {
+		p.Repos = append(p.Repos, prbot.Repository{Owner: "csweichel", Name: fmt.Sprintf("repo%d", i)})
+	}
+
+	res, _, failed := p.fetchAll(context.Background())
+	if failed != 0 || len(res) != repos {
+		t.Fatalf("expected %d repositories and no failures, got %d and %d", repos, len(res), failed)
+	}
+	if maxInFlight > concurrency {
+		t.Errorf("expected at most %d concurrent requests, got %d", concurrency, maxInFlight)
+	}
+	if maxInFlight < 2 {
+		t.Errorf("repositories were not fetched concurrently")
+	}
+}
+
+func TestForgetRepositories(t *testing.T) {
+	kept := prbot.Repository{Owner: "csweichel", Name: "kept"}
+	gone := prbot.Repository{Owner: "csweichel", Name: "gone"}
+	p := &poller{
+		Reports:      newReportStore(),
+		PullRequests: newPullRequestStore(),
+	}
+	for _, repo := range []prbot.Repository{kept, gone} {
+		report := prbot.ReportWIP([]prbot.PullRequest{{State: githubv4.PullRequestStateOpen}}, prbot.Options{})
+		updateMetrics(repo, metricsOptions{}, report)
+		p.Reports.Set(repo, report)
+		p.PullRequests.Set(repo, nil)
+	}
+	p.forgetRepositories(context.Background(), []prbot.Repository{kept, gone})
+	p.forgetRepositories(context.Background(), []prbot.Repository{kept})
+
+	for _, repo := range []prbot.Repository{kept, gone} {
+		_, reported := p.Reports.reports[repo.String()]
+		_, stored := p.PullRequests.prs[repo.String()]
+		_, counted := pullRequestsCount.known[repo.String()]
+		exp := repo == kept
+		if reported != exp || stored != exp || counted != exp {
+			t.Errorf("%s: expected reports, pull requests and metrics to be kept: %v, got %v, %v and %v", repo, exp, reported, stored, counted)
+		}
+	}
+	if n := testutil.CollectAndCount(prAgeHours); n != 1 {
+		t.Errorf("expected the age histogram of one repository, got %d", n)
+	}
+}
+
+func TestPollIsHealthyDespiteFailedRepositories(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) *http.Response {
+		body, _ := ioutil.ReadAll(req.Body)
+		if strings.Contains(string(body), `"name":"broken"`) {
+			return testResponse(`{"errors": [{"type": "NOT_FOUND", "message": "Could not resolve to a Repository with the name 'csweichel/broken'."}]}`, nil)
+		}
+		return testResponse(`{"data": {"repository": {"pullRequests": {"nodes": [], "pageInfo": {"hasNextPage": false}}}}}`, nil)
+	})
+	p := &poller{
+		Client:       githubv4.NewEnterpriseClient("https://github.test/api/graphql", &http.Client{Transport: transport}),
+		Repos:        []prbot.Repository{{Owner: "csweichel", Name: "working"}, {Owner: "csweichel", Name: "broken"}},
+		Retry:        backoff{MaxAttempts: 1},
+		Timeout:      10 * time.Second,
+		Reports:      newReportStore(),
+		PullRequests: newPullRequestStore(),
+		Health:       newHealthTracker(time.Hour),
+	}
+
+	if p.Poll(context.Background()) {
+		t.Error("expected the poll to report the failed repository")
+	}
+	if p.Health.lastSuccess.IsZero() {
+		t.Error("expected the poll to count towards health")
+	}
+	for repo, exp := range map[string]float64{"csweichel/working": 0, "csweichel/broken": 1} {
+		if v := testutil.ToFloat64(pollFailing.WithLabelValues(repo)); v != exp {
+			t.Errorf("%s: expected poll_failing %v, got %v", repo, exp, v)
+		}
+	}
+}
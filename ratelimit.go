@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitTransport records the rate limit reset time and the Retry-After GitHub reports in its
+// response headers
+type rateLimitTransport struct {
+	Base http.RoundTripper
+
+	mu         sync.RWMutex
+	resetAt    time.Time
+	retryAfter time.Time
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if reset := resp.Header.Get("X-RateLimit-Reset"); reset != "" {
+		if ts, err := strconv.ParseInt(reset, 10, 64); err == nil {
+			t.mu.Lock()
+			t.resetAt = time.Unix(ts, 0)
+			t.mu.Unlock()
+		}
+	}
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		// GitHub sends seconds, but HTTP also allows a date
+		var retryAfter time.Time
+		if secs, err := strconv.Atoi(after); err == nil {
+			retryAfter = time.Now().Add(time.Duration(secs) * time.Second)
+		} else if date, err := http.ParseTime(after); err == nil {
+			retryAfter = date
+		}
+		if !retryAfter.IsZero() {
+			t.mu.Lock()
+			t.retryAfter = retryAfter
+			t.mu.Unlock()
+		}
+	}
+	return resp, nil
+}
+
+// ResetAt returns the time at which the current rate limit window resets, or the zero time if unknown
+func (t *rateLimitTransport) ResetAt() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.resetAt
+}
+
+// RetryAt returns the time after which rate limited requests may be retried: that given by the
+// last Retry-After unless it passed already, and the rate limit reset otherwise. It returns the
+// zero time if neither is known.
+func (t *rateLimitTransport) RetryAt() time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.retryAfter.After(time.Now()) {
+		return t.retryAfter
+	}
+	return t.resetAt
+}
+
+// isRateLimitError returns true if err was caused by GitHub's primary or secondary rate limit
+func isRateLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "rate limit")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/shurcooL/githubv4"
+	log "github.com/sirupsen/logrus"
+)
+
+// roundTripFunc is an http.RoundTripper answering requests without network access
+type roundTripFunc func(req *http.Request) *http.Response
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+// testResponse returns a GraphQL response with body and headers
+func testResponse(body string, header http.Header) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Type", "application/json")
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestFetchWithRetryWaitsForRateLimitReset(t *testing.T) {
+	resetAt := time.Now().Add(time.Second).Truncate(time.Second)
+
+	var (
+		mu       sync.Mutex
+		requests []time.Time
+	)
+	rl := &rateLimitTransport{Base: roundTripFunc(func(req *http.Request) *http.Response {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, time.Now())
+		if len(requests) == 1 {
+			return testResponse(`{"errors": [{"type": "RATE_LIMITED", "message": "API rate limit exceeded for user ID 1."}]}`, http.Header{
+				"X-Ratelimit-Remaining": []string{"0"},
+				"X-Ratelimit-Reset":     []string{strconv.FormatInt(resetAt.Unix(), 10)},
+			})
+		}
+		return testResponse(`{"data": {"repository": {"pullRequests": {"nodes": [{"id": "pr1", "number": 1}], "pageInfo": {"hasNextPage": false}}}}}`, nil)
+	})}
+	p := &poller{
+		Client:     githubv4.NewEnterpriseClient("https://github.test/api/graphql", &http.Client{Transport: rl}),
+		RateLimits: rl,
+		Retry:      backoff{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		Timeout:    10 * time.Second,
+	}
+
+	prs, _, err := p.fetchWithRetry(context.Background(), log.NewEntry(log.StandardLogger()), func(ctx context.Context) ([]prbot.PullRequest, bool, error) {
+		return prbot.GetPullRequests(ctx, p.Client, "csweichel", "prbot", prbot.FetchOptions{})
+	})
+	if err != nil {
+		t.Fatalf("cannot fetch pull requests: %v", err)
+	}
+	if len(prs) != 1 {
+		t.Errorf("expected 1 pull request, got %d", len(prs))
+	}
+	if !rl.ResetAt().Equal(resetAt) {
+		t.Errorf("unexpected reset time: expected %v, got %v", resetAt, rl.ResetAt())
+	}
+	// rate limit errors are not retried with backoff, but after the reset
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	if requests[1].Before(resetAt) {
+		t.Errorf("retried at %v, before the rate limit reset at %v", requests[1], resetAt)
+	}
+}
+
+// rateLimitedOnce answers the first request as rate limited with header, and all others with an
+// empty page of pull requests. It records the times of the requests.
+func rateLimitedOnce(header http.Header) (*rateLimitTransport, func() []time.Time) {
+	var (
+		mu       sync.Mutex
+		requests []time.Time
+	)
+	rl := &rateLimitTransport{Base: roundTripFunc(func(req *http.Request) *http.Response {
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, time.Now())
+		if len(requests) == 1 {
+			return testResponse(`{"errors": [{"type": "RATE_LIMITED", "message": "API rate limit exceeded for user ID 1."}]}`, header)
+		}
+		return testResponse(`{"data": {"repository": {"pullRequests": {"nodes": [], "pageInfo": {"hasNextPage": false}}}}}`, nil)
+	})}
+	return rl, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), requests...)
+	}
+}
+
+func TestWaitForRateLimitReset(t *testing.T) {
+	inAnHour := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tests := []struct {
+		Name     string
+		Header   http.Header
+		Interval time.Duration
+	}{
+		{
+			Name:     "Retry-After before reset",
+			Header:   http.Header{"Retry-After": []string{"1"}, "X-Ratelimit-Reset": []string{inAnHour}},
+			Interval: time.Hour,
+		},
+		{
+			Name:     "capped at interval",
+			Header:   http.Header{"X-Ratelimit-Reset": []string{inAnHour}},
+			Interval: time.Second,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			rl, requests := rateLimitedOnce(test.Header)
+			p := &poller{
+				Client:     githubv4.NewEnterpriseClient("https://github.test/api/graphql", &http.Client{Transport: rl}),
+				RateLimits: rl,
+				Retry:      backoff{MaxAttempts: 1},
+				Interval:   test.Interval,
+				Timeout:    10 * time.Second,
+			}
+
+			_, _, err := p.fetchWithRetry(context.Background(), log.NewEntry(log.StandardLogger()), func(ctx context.Context) ([]prbot.PullRequest, bool, error) {
+				return prbot.GetPullRequests(ctx, p.Client, "csweichel", "prbot", prbot.FetchOptions{})
+			})
+			if err != nil {
+				t.Fatalf("cannot fetch pull requests: %v", err)
+			}
+			reqs := requests()
+			if len(reqs) != 2 {
+				t.Fatalf("expected 2 requests, got %d", len(reqs))
+			}
+			if wait := reqs[1].Sub(reqs[0]); wait < 900*time.Millisecond || wait > 5*time.Second {
+				t.Errorf("expected to wait about a second, waited %s", wait)
+			}
+		})
+	}
+}
+
+func TestPollReleasesLockWhileRateLimited(t *testing.T) {
+	rl, requests := rateLimitedOnce(http.Header{"Retry-After": []string{"1"}})
+	p := &poller{
+		Client:       githubv4.NewEnterpriseClient("https://github.test/api/graphql", &http.Client{Transport: rl}),
+		RateLimits:   rl,
+		Repos:        []prbot.Repository{{Owner: "csweichel", Name: "prbot"}},
+		Retry:        backoff{MaxAttempts: 1},
+		Interval:     time.Hour,
+		Timeout:      10 * time.Second,
+		Reports:      newReportStore(),
+		PullRequests: newPullRequestStore(),
+		Health:       newHealthTracker(time.Hour),
+	}
+
+	done := make(chan bool)
+	go func() { done <- p.Poll(context.Background()) }()
+	for len(requests()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		p.mu.Lock()
+		close(locked)
+		p.mu.Unlock()
+	}()
+	select {
+	case <-locked:
+	case <-done:
+		t.Fatal("poll finished before the lock was released")
+	}
+	if !<-done {
+		t.Error("expected the poll to succeed after waiting")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	log "github.com/sirupsen/logrus"
+)
+
+// reportStore holds the most recent report for each repository
+type reportStore struct {
+	mu      sync.RWMutex
+	reports map[string]storedReport
+}
+
+type storedReport struct {
+	GeneratedAt time.Time
+	Report      prbot.Report
+}
+
+func newReportStore() *reportStore {
+	return &reportStore{
+		reports: make(map[string]storedReport),
+	}
+}
+
+// Set replaces the report of a repository
+func (s *reportStore) Set(repo prbot.Repository, r prbot.Report) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.reports[repo.String()] = storedReport{
+		GeneratedAt: r.GeneratedAt,
+		Report:      r,
+	}
+}
+
+// Delete deletes the report of a repository
+func (s *reportStore) Delete(repo prbot.Repository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.reports, repo.String())
+}
+
+type reportPullRequestJSON struct {
+	Number int    `json:"number"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+}
+
+type reportJSON struct {
+	GeneratedAt        time.Time               `json:"generatedAt"`
+	Open               []reportPullRequestJSON `json:"open"`
+	Draft              []reportPullRequestJSON `json:"draft"`
+	Approved           []reportPullRequestJSON `json:"approved"`
+	ApprovedByRequired []reportPullRequestJSON `json:"approvedByRequired"`
+	ApprovedCIFailing  []reportPullRequestJSON `json:"approvedCIFailing"`
+	ApprovedStale      []reportPullRequestJSON `json:"approvedStale"`
+	ChangesRequested   []reportPullRequestJSON `json:"changesRequested"`
+	BlockedOnAuthor    []reportPullRequestJSON `json:"blockedOnAuthor"`
+	Commented          []reportPullRequestJSON `json:"commented"`
+	OverdueReview      []reportPullRequestJSON `json:"overdueReview"`
+	AwaitingAuthor     []reportPullRequestJSON `json:"awaitingAuthor"`
+	Unassigned         []reportPullRequestJSON `json:"unassigned"`
+}
+
+func toReportPullRequestsJSON(prs []*prbot.PullRequest) []reportPullRequestJSON {
+	res := make([]reportPullRequestJSON, 0, len(prs))
+	for _, pr := range prs {
+		res = append(res, reportPullRequestJSON{
+			Number: int(pr.Number),
+			URL:    pr.Link(),
+			Title:  string(pr.Title),
+			Author: pr.Author.Login,
+		})
+	}
+	return res
+}
+
+// ServeHTTP serves the most recent reports as JSON, keyed by repository
+func (s *reportStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	res := make(map[string]reportJSON, len(s.reports))
+	for repo, sr := range s.reports {
+		res[repo] = reportJSON{
+			GeneratedAt:        sr.GeneratedAt,
+			Open:               toReportPullRequestsJSON(sr.Report.Open),
+			Draft:              toReportPullRequestsJSON(sr.Report.Draft),
+			Approved:           toReportPullRequestsJSON(sr.Report.Approved),
+			ApprovedByRequired: toReportPullRequestsJSON(sr.Report.ApprovedByRequired),
+			ApprovedCIFailing:  toReportPullRequestsJSON(sr.Report.ApprovedCIFailing),
+			ApprovedStale:      toReportPullRequestsJSON(sr.Report.ApprovedStale),
+			ChangesRequested:   toReportPullRequestsJSON(sr.Report.ChangesRequested),
+			BlockedOnAuthor:    toReportPullRequestsJSON(sr.Report.BlockedOnAuthor),
+			Commented:          toReportPullRequestsJSON(sr.Report.Commented),
+			OverdueReview:      toReportPullRequestsJSON(sr.Report.OverdueReview),
+			AwaitingAuthor:     toReportPullRequestsJSON(sr.Report.AwaitingAuthor),
+			Unassigned:         toReportPullRequestsJSON(sr.Report.Unassigned),
+		}
+	}
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(res)
+	if err != nil {
+		log.WithError(err).Warn("cannot serve report")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// backoff configures how often and how patiently an operation is retried
+type backoff struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with every further attempt.
+	BaseDelay time.Duration
+}
+
+// retry calls fn until it succeeds, returns a non-retryable error, the attempts are exhausted or
+// ctx is cancelled. Delays between attempts grow exponentially and are jittered.
+func retry(ctx context.Context, logger *log.Entry, b backoff, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryableError(err) || attempt >= b.MaxAttempts {
+			return err
+		}
+
+		delay := b.BaseDelay << (attempt - 1)
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		logger.WithError(err).WithField("attempt", attempt).Warnf("retrying in %s", delay.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isRetryableError returns false for errors which won't go away by trying again, e.g. because
+// the token is invalid or the repository does not exist.
+func isRetryableError(err error) bool {
+	if err == nil || isRateLimitError(err) {
+		return false
+	}
+	return !isPermissionError(err)
+}
+
+// isPermissionError returns true if err means that the token is invalid, lacks the permissions
+// to access a repository, or that the repository does not exist. GitHub does not tell those apart
+// for private repositories.
+func isPermissionError(err error) bool {
+	if err == nil || isRateLimitError(err) {
+		return false
+	}
+
+	msg := err.Error()
+	for _, denied := range []string{
+		"status code: 401",
+		"status code: 403",
+		"status code: 404",
+		"Could not resolve to a Repository",
+		"Could not resolve to an Organization",
+		"Resource not accessible by integration",
+	} {
+		if strings.Contains(msg, denied) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sweptGaugeVec is a GaugeVec whose series are updated in rounds. Any series which was set in the
+// previous round of a scope (e.g. a repository) but not in the current one is deleted, so that
+// series of closed PRs, or authors without PRs, stop being reported.
+type sweptGaugeVec struct {
+	*prometheus.GaugeVec
+
+	mu    sync.Mutex
+	known map[string]map[string][]string
+}
+
+func newSweptGaugeVec(opts prometheus.GaugeOpts, labelNames []string) *sweptGaugeVec {
+	return &sweptGaugeVec{
+		GaugeVec: prometheus.NewGaugeVec(opts, labelNames),
+		known:    make(map[string]map[string][]string),
+	}
+}
+
+// sweepRound collects the series set during one update of a scope
+type sweepRound struct {
+	vec   *sweptGaugeVec
+	scope string
+	seen  map[string][]string
+}
+
+// Begin starts a new round for scope. Rounds of the same scope must not overlap.
+func (v *sweptGaugeVec) Begin(scope string) *sweepRound {
+	return &sweepRound{
+		vec:   v,
+		scope: scope,
+		seen:  make(map[string][]string),
+	}
+}
+
+// Set sets the series identified by lvs and marks it as seen in this round
+func (r *sweepRound) Set(value float64, lvs ...string) {
+	r.vec.WithLabelValues(lvs...).Set(value)
+	r.seen[strings.Join(lvs, "\xff")] = lvs
+}
+
+// Delete deletes all series of scope
+func (v *sweptGaugeVec) Delete(scope string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for _, lvs := range v.known[scope] {
+		v.DeleteLabelValues(lvs...)
+	}
+	delete(v.known, scope)
+}
+
+// End deletes all series of the scope which were seen in the previous round, but not in this one
+func (r *sweepRound) End() {
+	r.vec.mu.Lock()
+	defer r.vec.mu.Unlock()
+
+	for key, lvs := range r.vec.known[r.scope] {
+		if _, ok := r.seen[key]; !ok {
+			r.vec.DeleteLabelValues(lvs...)
+		}
+	}
+	r.vec.known[r.scope] = r.seen
+}
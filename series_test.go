@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSweepRoundDeletesUnseenSeries(t *testing.T) {
+	vec := newSweptGaugeVec(prometheus.GaugeOpts{Name: "test_sweep"}, []string{"repo", "number"})
+
+	round := vec.Begin("csweichel/prbot")
+	round.Set(1, "csweichel/prbot", "1")
+	round.Set(1, "csweichel/prbot", "2")
+	round.End()
+	other := vec.Begin("csweichel/other")
+	other.Set(1, "csweichel/other", "1")
+	other.End()
+	if n := testutil.CollectAndCount(vec); n != 3 {
+		t.Fatalf("expected 3 series, got %d", n)
+	}
+
+	// #2 dropped out of the result set
+	round = vec.Begin("csweichel/prbot")
+	round.Set(1, "csweichel/prbot", "1")
+	round.End()
+	if n := testutil.CollectAndCount(vec); n != 2 {
+		t.Errorf("expected 2 series, got %d", n)
+	}
+	if v := testutil.ToFloat64(vec.WithLabelValues("csweichel/other", "1")); v != 1 {
+		t.Errorf("series of another scope was deleted")
+	}
+}
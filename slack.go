@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+)
+
+// slackNotifier posts PRs which became overdue since the previous poll to a Slack webhook
+type slackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+	// Template renders each PR of a message
+	Template *template.Template
+
+	overdue transitionTracker
+}
+
+func newSlackNotifier(webhookURL string, tmpl *template.Template) *slackNotifier {
+	return &slackNotifier{
+		WebhookURL: webhookURL,
+		Client:     &http.Client{Timeout: 30 * time.Second},
+		Template:   tmpl,
+	}
+}
+
+// NotifyOverdue posts the PRs of report which were not overdue as of the last successful post. The
+// first report of a repository only establishes the baseline so that a restart does not repeat every
+// overdue PR. Failed posts are retried at the next call.
+func (n *slackNotifier) NotifyOverdue(ctx context.Context, repo prbot.Repository, report prbot.Report) error {
+	newlyOverdue, seen := n.overdue.Peek(repo, report.OverdueReview)
+	if !seen || len(newlyOverdue) == 0 {
+		n.overdue.Commit(repo, report.OverdueReview)
+		return nil
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "%d PRs in %s became overdue:\n", len(newlyOverdue), repo)
+	for _, pr := range newlyOverdue {
+		line, err := renderNotification(n.Template, repo, pr, "overdue")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(&msg, line)
+	}
+	err := n.post(ctx, msg.String())
+	if err != nil {
+		return err
+	}
+	n.overdue.Commit(repo, report.OverdueReview)
+	return nil
+}
+
+func (n *slackNotifier) post(ctx context.Context, text string) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{Text: text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cannot create Slack request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot post to Slack: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot post to Slack: %s", resp.Status)
+	}
+	return nil
+}
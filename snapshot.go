@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+)
+
+// snapshotWriter appends the reports of each poll as a JSON line to a file. Once the file would
+// grow beyond MaxBytes, it is moved to Path.1, replacing the previous one, and a new file is started.
+type snapshotWriter struct {
+	Path     string
+	MaxBytes int64
+}
+
+type snapshotJSON struct {
+	Time  time.Time                   `json:"time"`
+	Repos map[string][]snapshotPRJSON `json:"repos"`
+}
+
+type snapshotPRJSON struct {
+	Number    int       `json:"number"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Buckets   []string  `json:"buckets"`
+}
+
+// Write appends the reports of a poll at t, keyed by repository
+func (s *snapshotWriter) Write(t time.Time, reports map[string]prbot.Report) error {
+	snapshot := snapshotJSON{Time: t, Repos: make(map[string][]snapshotPRJSON, len(reports))}
+	for repo, r := range reports {
+		buckets := r.BucketsByNumber()
+		prs := make([]snapshotPRJSON, 0, len(buckets))
+		seen := make(map[int]bool, len(buckets))
+		// Open lacks the drafts if Options.OpenExcludesDrafts is set
+		for _, pr := range append(append([]*prbot.PullRequest{}, r.Open...), r.Draft...) {
+			n := int(pr.Number)
+			if seen[n] {
+				continue
+			}
+			seen[n] = true
+			states := buckets[n]
+			if states == nil {
+				states = []string{}
+			}
+			prs = append(prs, snapshotPRJSON{
+				Number:    n,
+				Author:    pr.Author.Login,
+				CreatedAt: pr.CreatedAt.Time,
+				UpdatedAt: pr.UpdatedAt.Time,
+				Buckets:   states,
+			})
+		}
+		sort.Slice(prs, func(i, j int) bool { return prs[i].Number < prs[j].Number })
+		snapshot.Repos[repo] = prs
+	}
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("cannot marshal snapshot: %v", err)
+	}
+	line = append(line, '\n')
+
+	err = s.rotate(int64(len(line)))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open snapshot file: %v", err)
+	}
+	_, err = f.Write(line)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write snapshot file: %v", err)
+	}
+	return f.Close()
+}
+
+// rotate moves the snapshot file aside if appending n bytes would make it exceed MaxBytes
+func (s *snapshotWriter) rotate(n int64) error {
+	stat, err := os.Stat(s.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot stat snapshot file: %v", err)
+	}
+	if s.MaxBytes <= 0 || stat.Size() == 0 || stat.Size()+n <= s.MaxBytes {
+		return nil
+	}
+
+	err = os.Rename(s.Path, s.Path+".1")
+	if err != nil {
+		return fmt.Errorf("cannot rotate snapshot file: %v", err)
+	}
+	return nil
+}
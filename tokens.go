@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tokenPoolTransport authenticates each request with the next of several GitHub tokens, which
+// spreads the requests over the rate limits of all of them. Tokens GitHub rejects are dropped from
+// the pool, unless they are the last one left.
+type tokenPoolTransport struct {
+	Base http.RoundTripper
+
+	mu     sync.Mutex
+	tokens []string
+	next   int
+}
+
+func newTokenPoolTransport(tokens []string, base http.RoundTripper) *tokenPoolTransport {
+	return &tokenPoolTransport{
+		Base:   base,
+		tokens: tokens,
+	}
+}
+
+func (t *tokenPoolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for {
+		token, last := t.pick()
+
+		r := req.Clone(req.Context())
+		if req.Body != nil && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("cannot rewind request body: %v", err)
+			}
+			r.Body = body
+		}
+		r.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := t.Base.RoundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		rewindable := req.Body == nil || req.GetBody != nil
+		if resp.StatusCode != http.StatusUnauthorized || last || !rewindable {
+			return resp, nil
+		}
+		resp.Body.Close()
+		t.drop(req.Context(), token)
+	}
+}
+
+// pick returns the next token, and whether it is the only one left
+func (t *tokenPoolTransport) pick() (token string, last bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.next = (t.next + 1) % len(t.tokens)
+	return t.tokens[t.next], len(t.tokens) == 1
+}
+
+// drop removes token from the pool
+func (t *tokenPoolTransport) drop(ctx context.Context, token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, tkn := range t.tokens {
+		if tkn != token || len(t.tokens) == 1 {
+			continue
+		}
+		t.tokens = append(t.tokens[:i], t.tokens[i+1:]...)
+		log.WithContext(ctx).WithField("remaining", len(t.tokens)).Warn("GitHub rejected one of GITHUB_TOKENS, skipping it from now on")
+		return
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type pollIDKey struct{}
+
+// withPollID returns ctx carrying a new random poll ID
+func withPollID(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pollIDKey{}, fmt.Sprintf("%016x", rand.Uint64()))
+}
+
+// pollIDFrom returns the poll ID of ctx, or the empty string if it has none
+func pollIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(pollIDKey{}).(string)
+	return id
+}
+
+// pollIDHook adds the poll ID to all log entries created with log.WithContext, so that the log
+// lines of a single poll can be told apart from those of overlapping ones
+type pollIDHook struct{}
+
+func (pollIDHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (pollIDHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	if id := pollIDFrom(entry.Context); id != "" {
+		entry.Data["poll"] = id
+	}
+	return nil
+}
+
+// tracingTransport sends the poll ID of each request as X-Request-Id and logs the request's
+// duration together with the ID GitHub assigned to it
+type tracingTransport struct {
+	Base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if id := pollIDFrom(ctx); id != "" {
+		req = req.Clone(ctx)
+		req.Header.Set("X-Request-Id", id)
+	}
+
+	start := time.Now()
+	resp, err := t.Base.RoundTrip(req)
+	logger := log.WithContext(ctx).WithField("url", req.URL.String()).WithField("duration", time.Since(start).String())
+	if err != nil {
+		logger.WithError(err).Debug("GitHub request failed")
+		return nil, err
+	}
+	logger.WithField("status", resp.StatusCode).WithField("githubRequestID", resp.Header.Get("X-GitHub-Request-Id")).Debug("GitHub request")
+	return resp, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	"github.com/shurcooL/githubv4"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+func TestPollIDIsLogged(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.AddHook(pollIDHook{})
+	logger.SetLevel(log.DebugLevel)
+	ctx := withPollID(context.Background())
+	id := pollIDFrom(ctx)
+
+	var pr prbot.PullRequest
+	pr.State = githubv4.PullRequestStateOpen
+	prbot.ReportWIP([]prbot.PullRequest{pr}, prbot.Options{Logger: logger.WithContext(ctx)})
+
+	// the PR has no repository, so grouping it logs a warning
+	std := log.StandardLogger()
+	std.AddHook(hook)
+	std.AddHook(pollIDHook{})
+	defer std.ReplaceHooks(make(log.LevelHooks))
+	groupByRepository(ctx, []prbot.PullRequest{pr})
+
+	if len(hook.Entries) != 2 {
+		t.Fatalf("expected 2 log entries, got %d", len(hook.Entries))
+	}
+	for _, entry := range hook.Entries {
+		if entry.Data["poll"] != id {
+			t.Errorf("%q: expected poll %s, got %v", entry.Message, id, entry.Data["poll"])
+		}
+	}
+}
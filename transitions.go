@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/csweichel/prbot/pkg/prbot"
+	log "github.com/sirupsen/logrus"
+)
+
+// transitionTracker remembers the PRs of a bucket per repository to find the PRs which entered it
+// since the previous poll. The zero value is ready to use.
+type transitionTracker struct {
+	mu sync.Mutex
+	// members contains the numbers of the bucket's PRs per repository as of the previous poll
+	members map[string]map[int]struct{}
+}
+
+// Entered returns the PRs of the bucket which were not in it at the previous call for repo. seen is
+// false on the first call for a repository, which only establishes the baseline.
+func (t *transitionTracker) Entered(repo prbot.Repository, bucket []*prbot.PullRequest) (entered []*prbot.PullRequest, seen bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entered, seen = t.diff(repo, bucket)
+	t.set(repo, bucket)
+	return entered, seen
+}
+
+// Peek is like Entered but keeps the baseline, which Commit advances. This lets callers compare
+// against the same baseline until they successfully acted on the result.
+func (t *transitionTracker) Peek(repo prbot.Repository, bucket []*prbot.PullRequest) (entered []*prbot.PullRequest, seen bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.diff(repo, bucket)
+}
+
+// Commit makes bucket the baseline of repo
+func (t *transitionTracker) Commit(repo prbot.Repository, bucket []*prbot.PullRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.set(repo, bucket)
+}
+
+func (t *transitionTracker) diff(repo prbot.Repository, bucket []*prbot.PullRequest) (entered []*prbot.PullRequest, seen bool) {
+	previous, seen := t.members[repo.String()]
+	for _, pr := range bucket {
+		if _, ok := previous[int(pr.Number)]; !ok {
+			entered = append(entered, pr)
+		}
+	}
+	return entered, seen
+}
+
+func (t *transitionTracker) set(repo prbot.Repository, bucket []*prbot.PullRequest) {
+	if t.members == nil {
+		t.members = make(map[string]map[int]struct{})
+	}
+	current := make(map[int]struct{}, len(bucket))
+	for _, pr := range bucket {
+		current[int(pr.Number)] = struct{}{}
+	}
+	t.members[repo.String()] = current
+}
+
+// bucketLog logs a structured event whenever a PR moves between buckets from one poll to the next.
+// The zero value logs to the standard logger.
+type bucketLog struct {
+	Logger *log.Logger
+
+	mu sync.Mutex
+	// buckets contains the states of each PR by number per repository as of the previous poll
+	buckets map[string]map[int][]string
+}
+
+// Log logs the PRs whose buckets differ from those at the previous call for repo, including PRs
+// which were opened or closed in between. The first call for a repository only establishes the
+// baseline.
+func (l *bucketLog) Log(repo prbot.Repository, report prbot.Report) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.buckets == nil {
+		l.buckets = make(map[string]map[int][]string)
+	}
+	previous, seen := l.buckets[repo.String()]
+	current := report.BucketsByNumber()
+	l.buckets[repo.String()] = current
+	if !seen {
+		return
+	}
+
+	logger := l.Logger
+	if logger == nil {
+		logger = log.StandardLogger()
+	}
+	event := func(nr int, from, to string) {
+		logger.WithFields(log.Fields{
+			"repo":   repo.String(),
+			"number": nr,
+			"from":   from,
+			"to":     to,
+		}).Info("PR changed buckets")
+	}
+	for nr, states := range current {
+		before, ok := previous[nr]
+		if !ok {
+			event(nr, "(new)", formatStates(states))
+			continue
+		}
+		if formatStates(before) != formatStates(states) {
+			event(nr, formatStates(before), formatStates(states))
+		}
+	}
+	for nr, states := range previous {
+		if _, ok := current[nr]; !ok {
+			event(nr, formatStates(states), "(gone)")
+		}
+	}
+}
+
+// formatStates joins the sorted states, or returns "open" if there are none
+func formatStates(states []string) string {
+	if len(states) == 0 {
+		return "open"
+	}
+	return strings.Join(states, ",")
+}
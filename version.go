@@ -0,0 +1,9 @@
+package main
+
+// version information, set at build time via
+// -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)